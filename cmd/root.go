@@ -13,8 +13,10 @@ import (
 	"github.com/hylodoc/hylodoc.com/internal/config"
 	"github.com/hylodoc/hylodoc.com/internal/dns"
 	"github.com/hylodoc/hylodoc.com/internal/email/emailqueue"
+	"github.com/hylodoc/hylodoc.com/internal/gc"
 	"github.com/hylodoc/hylodoc.com/internal/httpclient"
 	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/visits"
 )
 
 const clientTimeout = 30 * time.Second
@@ -38,6 +40,28 @@ var rootCmd = &cobra.Command{
 				log.Fatal("email queue error", err)
 			}
 		}()
+		go func() {
+			params := config.Config.Routing.Visits
+			if err := visits.RunRollups(
+				store, params.RollupPeriod, params.Retention,
+			); err != nil {
+				log.Fatal("visit rollup error", err)
+			}
+		}()
+		go func() {
+			params := config.Config.SSG.GC
+			if err := gc.Run(
+				store,
+				gc.Paths{
+					Websites:  config.Config.Hylodoc.WebsitesPath,
+					Checkouts: config.Config.Hylodoc.CheckoutsPath,
+					Manifests: config.Config.Hylodoc.ManifestsPath,
+				},
+				params.Period, params.Grace, params.DryRun,
+			); err != nil {
+				log.Fatal("gc error", err)
+			}
+		}()
 		return server.Serve(c, store)
 	},
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -13,6 +14,13 @@ import (
 	"github.com/hylodoc/hylodoc.com/internal/util"
 )
 
+// A StatusError is an error whose message can be shown to the client along
+// with its status code, such as one rejecting invalid input.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
 func HandleError(w http.ResponseWriter, r *http.Request, err error) {
 	sesh, ok := r.Context().Value(session.CtxSessionKey).(*session.Session)
 	assert.Assert(ok)
@@ -23,10 +31,31 @@ func HandleError(w http.ResponseWriter, r *http.Request, err error) {
 		return
 	}
 
+	var statusErr StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode() != 0 {
+		sesh.Println("client error:", err)
+		clientError(w, r, statusErr)
+		return
+	}
+
 	sesh.Println("internal server error:", err)
 	internalServerError(w, r)
 }
 
+/* JSON requests get {"message": ...} back, as the forms submitting them
+ * expect */
+func clientError(w http.ResponseWriter, r *http.Request, err StatusError) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, err.Error(), err.StatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.StatusCode())
+	json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{err.Error()})
+}
+
 func internalServerError(w http.ResponseWriter, r *http.Request) {
 	sesh, ok := r.Context().Value(session.CtxSessionKey).(*session.Session)
 	assert.Assert(ok)
@@ -167,3 +196,61 @@ func NotFoundDomain(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 }
+
+func SiteOffline(w http.ResponseWriter, r *http.Request, message string) {
+	if message == "" {
+		message = "This site is currently offline. Please check back later."
+	}
+	siteUnavailable(w, r, "offline", "Site offline", message)
+}
+
+// SiteBuilding is served for sites whose first generation hasn't completed.
+func SiteBuilding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "10")
+	siteUnavailable(
+		w, r, "building", "Site building",
+		"This site is being built. Please check back in a moment.",
+	)
+}
+
+func siteUnavailable(
+	w http.ResponseWriter, r *http.Request, reason, title, message string,
+) {
+	sesh, ok := r.Context().Value(session.CtxSessionKey).(*session.Session)
+	assert.Assert(ok)
+	sesh.Printf("503 (%s) %s %s\n", reason, r.Host, r.URL)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := response.NewTemplate(
+		[]string{"offline.html"},
+		util.PageInfo{
+			Data: struct {
+				Title    string
+				UserInfo *session.UserInfo
+				Message  string
+			}{
+				Title:    title,
+				UserInfo: session.ConvertSessionToUserInfoError(sesh),
+				Message:  message,
+			},
+		},
+	).Respond(w, r); err != nil {
+		sesh.Println(
+			"pathological error:",
+			err,
+		)
+	}
+}
+
+/* TooManyRequests is deliberately cheap, since it's served to clients that are
+ * already sending too many requests */
+func TooManyRequests(w http.ResponseWriter, r *http.Request) {
+	sesh, ok := r.Context().Value(session.CtxSessionKey).(*session.Session)
+	assert.Assert(ok)
+	sesh.Println("429", r.Host, r.URL)
+	w.Header().Set("Retry-After", "1")
+	http.Error(
+		w,
+		http.StatusText(http.StatusTooManyRequests),
+		http.StatusTooManyRequests,
+	)
+}
@@ -2,9 +2,14 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hylodoc/hylodoc.com/internal/app/handler"
@@ -20,8 +25,10 @@ import (
 	"github.com/hylodoc/hylodoc.com/internal/model"
 	"github.com/hylodoc/hylodoc.com/internal/routing"
 	"github.com/hylodoc/hylodoc.com/internal/session"
+	"github.com/hylodoc/hylodoc.com/internal/sitecache"
 	"github.com/hylodoc/hylodoc.com/internal/user"
 	"github.com/hylodoc/hylodoc.com/internal/util"
+	"github.com/hylodoc/hylodoc.com/internal/visits"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -30,6 +37,8 @@ const (
 	httpPort        = 80
 	httpsPort       = 443
 	metricsHttpPort = 8000
+
+	shutdownTimeout = 30 * time.Second
 )
 
 func Serve(httpClient *httpclient.Client, store *model.Store) error {
@@ -75,24 +84,48 @@ func Serve(httpClient *httpclient.Client, store *model.Store) error {
 		}
 	}()
 
-	bootid, err := store.Boot(context.TODO())
+	instance, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("hostname: %w", err)
+	}
+	bootid, err := store.Boot(context.TODO(), instance)
 	if err != nil {
 		return fmt.Errorf("cannot boot: %w", err)
 	}
 	log.Println("bootid", bootid)
 
+	if err := blog.StartBuilders(
+		store, bootid, config.Config.SSG.Workers,
+		config.Config.SSG.QueueSize,
+	); err != nil {
+		return fmt.Errorf("builders: %w", err)
+	}
+
+	if err := sitecache.SetTTL(config.Config.Routing.CacheTTL); err != nil {
+		return fmt.Errorf("cache ttl: %w", err)
+	}
+
+	params := config.Config.Routing.Visits
+	recorder, err := visits.NewRecorder(
+		store, params.BufferSize, params.BatchSize, params.FlushPeriod,
+	)
+	if err != nil {
+		return fmt.Errorf("visit recorder: %w", err)
+	}
+	go recorder.Run()
+
 	r := mux.NewRouter()
 
 	/* middleware */
 	r.Use(session.NewSessionService(store).Middleware)
 	r.Use(metrics.Middleware)
-	r.Use(routing.NewRoutingService(store).Middleware)
+	r.Use(routing.NewRoutingService(store, recorder).Middleware)
 
 	/* MethodNotAllowed handler ignored for now */
 	notfoundR := mux.NewRouter()
 	notfoundR.Use(session.NewSessionService(store).Middleware)
 	notfoundR.Use(metrics.Middleware)
-	notfoundR.Use(routing.NewRoutingService(store).Middleware)
+	notfoundR.Use(routing.NewRoutingService(store, recorder).Middleware)
 	notfoundR.PathPrefix("/").HandlerFunc(handler.NotFound)
 	r.NotFoundHandler = notfoundR
 
@@ -122,8 +155,11 @@ func Serve(httpClient *httpclient.Client, store *model.Store) error {
 	handler.Handle(r, "/stripe/webhook", billingService.StripeWebhook)
 	handler.Handle(r, "/pricing", billingService.Pricing)
 
-	handler.Handle(r, "/blogs/{blogID}/subscribe", blogService.SubscribeToBlog).Methods("POST")
+	subscribeR := r.PathPrefix("/blogs/{blogID}/subscribe").Subrouter()
+	subscribeR.Use(routing.LimitSubscriptions)
+	handler.Handle(subscribeR, "", blogService.SubscribeToBlog).Methods("POST")
 	handler.Handle(r, "/blogs/unsubscribe", blogService.UnsubscribeFromBlog)
+	handler.Handle(r, "/stats/{token}", blogService.PublicStats)
 
 	/* authenticated routes */
 	authR := r.PathPrefix("/user").Subrouter()
@@ -148,13 +184,23 @@ func Serve(httpClient *httpclient.Client, store *model.Store) error {
 	handler.Handle(blogR, "/set-domain", blogService.DomainSubmit)
 	handler.Handle(blogR, "/set-theme", blogService.ThemeSubmit)
 	handler.Handle(blogR, "/set-live-branch", blogService.LiveBranchSubmit)
+	handler.Handle(blogR, "/set-content-root", blogService.ContentRootSubmit)
+	handler.Handle(blogR, "/set-preview-branches", blogService.PreviewBranchesSubmit)
 	handler.Handle(blogR, "/set-status", blogService.SetStatusSubmit)
+	handler.Handle(blogR, "/set-offline-message", blogService.SetOfflineMessageSubmit)
 	handler.Handle(blogR, "/set-email-mode", blogService.SetEmailModeSubmit)
+	handler.Handle(blogR, "/set-feed-settings", blogService.FeedSettingsSubmit)
+	handler.Handle(blogR, "/set-public-stats", blogService.SetPublicStatsSubmit)
 	handler.Handle(blogR, "/sync", blogService.SyncRepository)
+	handler.Handle(blogR, "/builds", blogService.Builds)
+	handler.Handle(blogR, "/pin", blogService.PinSubmit).Methods("POST")
+	handler.Handle(blogR, "/unpin", blogService.UnpinSubmit).Methods("POST")
 	handler.Handle(blogR, "/email", blogService.SendPostEmail)
 	handler.Handle(blogR, "/delete", blogService.Delete)
 
 	handler.Handle(blogR, "/metrics", blogService.SiteMetrics)
+	handler.Handle(blogR, "/metrics/views", blogService.TrafficMetrics)
+	handler.Handle(blogR, "/metrics/export", blogService.ExportMetrics)
 	handler.Handle(blogR, "/subscriber/metrics", blogService.SubscriberMetrics)
 	handler.Handle(blogR, "/subscriber/export", blogService.ExportSubscribers)
 	handler.Handle(blogR, "/subscriber/edit", blogService.EditSubscriber)
@@ -193,6 +239,33 @@ func Serve(httpClient *httpclient.Client, store *model.Store) error {
 		TLSConfig: m.TLSConfig(),
 		Handler:   r,
 	}
+	/* on shutdown, finish in-flight requests and builds before draining the
+	 * visits recorded */
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Println("shutting down...")
+		ctx, cancel := context.WithTimeout(
+			context.Background(), shutdownTimeout,
+		)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println("shutdown:", err)
+		}
+	}()
+	if err := listen(s); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-stopped
+	blog.StopBuilders()
+	recorder.Close()
+	return nil
+}
+
+func listen(s *http.Server) error {
 	switch config.Config.Hylodoc.Protocol {
 	case "https":
 		log.Printf("listening at https://localhost:%d...\n", httpsPort)
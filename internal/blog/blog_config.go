@@ -2,14 +2,19 @@ package blog
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
 	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
 	"github.com/hylodoc/hylodoc.com/internal/authz"
+	"github.com/hylodoc/hylodoc.com/internal/blog/internal/contentroot"
+	"github.com/hylodoc/hylodoc.com/internal/blog/internal/previewbranches"
 	"github.com/hylodoc/hylodoc.com/internal/config"
 	"github.com/hylodoc/hylodoc.com/internal/httpclient"
 	"github.com/hylodoc/hylodoc.com/internal/model"
@@ -46,6 +51,10 @@ func (b *BlogService) Config(
 	if err != nil {
 		return nil, fmt.Errorf("get blog info: %w", err)
 	}
+	previews, err := getPreviewsInfo(b.store, blogID)
+	if err != nil {
+		return nil, fmt.Errorf("get previews info: %w", err)
+	}
 	userID, err := sesh.GetUserID()
 	if err != nil {
 		return nil, fmt.Errorf("get user id: %w", err)
@@ -65,6 +74,7 @@ func (b *BlogService) Config(
 				UserInfo        *session.UserInfo
 				ID              string
 				Blog            BlogInfo
+				Previews        []PreviewInfo
 				Themes          []string
 				CurrentTheme    string
 				CanCustomDomain bool
@@ -74,6 +84,7 @@ func (b *BlogService) Config(
 				UserInfo:        session.ConvertSessionToUserInfo(sesh),
 				ID:              blogID,
 				Blog:            blogInfo,
+				Previews:        previews,
 				Themes:          BuildThemes(config.Config.SSG.Themes),
 				CurrentTheme:    string(blogInfo.Theme),
 				CanCustomDomain: canConfigure,
@@ -167,9 +178,7 @@ func regenerateBlog(blogID string, s *model.Store) error {
 	); err != nil {
 		return fmt.Errorf("mark blog generations stale: %w", err)
 	}
-	if _, err := GetFreshGeneration(blogID, s); err != nil {
-		return fmt.Errorf("get fresh generation: %w", err)
-	}
+	s.AfterCommit(func() { EnqueueBuild(blogID, "") })
 	return nil
 }
 
@@ -243,6 +252,119 @@ func updateBlogLiveBranchTx(
 	return nil
 }
 
+/* Content root */
+
+// ContentRootSubmit sets the directory of the repository that the blog is
+// generated from, which is its root if empty.
+func (b *BlogService) ContentRootSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("ContentRootSubmit handler...")
+
+	r.MixpanelTrack("ContentRootSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+
+	var req struct {
+		ContentRoot string `json:"content_root"`
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	root, err := contentroot.Parse(req.ContentRoot)
+	if err != nil {
+		return nil, createCustomError(
+			fmt.Sprintf("Content root %s.", err), http.StatusBadRequest,
+		)
+	}
+
+	if err := b.store.ExecTx(
+		func(tx *model.Store) error {
+			if err := tx.SetContentRootByID(
+				context.TODO(),
+				model.SetContentRootByIDParams{
+					ID:          blogID,
+					ContentRoot: root,
+				},
+			); err != nil {
+				return fmt.Errorf("set content root: %w", err)
+			}
+			return regenerateBlog(blogID, tx)
+		},
+	); err != nil {
+		return nil, fmt.Errorf("update content root tx: %w", err)
+	}
+
+	return response.NewJson(struct {
+		Message string `json:"message"`
+	}{"Content root submitted successfully!"})
+}
+
+/* Previews */
+
+// PreviewBranchesSubmit sets the patterns of the branches that are built as
+// previews, taking down the previews of branches that no longer match.
+func (b *BlogService) PreviewBranchesSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("PreviewBranchesSubmit handler...")
+
+	r.MixpanelTrack("PreviewBranchesSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+
+	var req struct {
+		PreviewBranches string `json:"preview_branches"`
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	patterns, err := previewbranches.Parse(req.PreviewBranches)
+	if err != nil {
+		return nil, createCustomError(
+			fmt.Sprintf("Preview branches %s.", err),
+			http.StatusBadRequest,
+		)
+	}
+
+	if err := b.store.ExecTx(
+		func(tx *model.Store) error {
+			if err := tx.SetPreviewBranchesByID(
+				context.TODO(),
+				model.SetPreviewBranchesByIDParams{
+					ID:              blogID,
+					PreviewBranches: patterns,
+				},
+			); err != nil {
+				return fmt.Errorf("set preview branches: %w", err)
+			}
+			return deletePreviewsNotMatching(blogID, patterns, tx)
+		},
+	); err != nil {
+		return nil, fmt.Errorf("update preview branches tx: %w", err)
+	}
+
+	return response.NewJson(struct {
+		Message string `json:"message"`
+	}{"Preview branches submitted successfully!"})
+}
+
 func (b *BlogService) SetStatusSubmit(
 	r request.Request,
 ) (response.Response, error) {
@@ -346,8 +468,8 @@ func setBlogToLive(
 	if err := s.SetBlogToLive(context.TODO(), b.ID); err != nil {
 		return nil, err
 	}
-	if _, err := GetFreshGeneration(b.ID, s); err != nil {
-		return nil, fmt.Errorf("cannot generate: %w", err)
+	if err := enqueueIfStale(b.ID, s); err != nil {
+		return nil, fmt.Errorf("enqueue if stale: %w", err)
 	}
 	return &statusChangeResponse{true}, nil
 }
@@ -361,6 +483,129 @@ func setBlogToOffline(
 	return &statusChangeResponse{false}, nil
 }
 
+/* the length of blogs.offline_message */
+const maxOfflineMessageLength = 1000
+
+func (b *BlogService) SetOfflineMessageSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("SetOfflineMessageSubmit handler...")
+
+	r.MixpanelTrack("SetOfflineMessageSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+
+	var req struct {
+		Message string `json:"message"`
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	message := strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(message) > maxOfflineMessageLength {
+		return nil, createCustomError(
+			fmt.Sprintf(
+				"Offline message cannot be longer than %d characters.",
+				maxOfflineMessageLength,
+			),
+			http.StatusBadRequest,
+		)
+	}
+
+	if err := b.store.SetBlogOfflineMessage(
+		context.TODO(),
+		model.SetBlogOfflineMessageParams{
+			ID:             blogID,
+			OfflineMessage: wrapNullString(message),
+		},
+	); err != nil {
+		return nil, fmt.Errorf("set offline message: %w", err)
+	}
+
+	return response.NewJson(struct {
+		Message string `json:"message"`
+	}{"Offline message saved!"})
+}
+
+/* Feeds */
+
+/* most entries a blog's feeds can be set to have */
+const maxFeedEntries = 1000
+
+// FeedSettingsSubmit sets the number of entries in the blog's feeds and
+// whether they carry the posts' HTML. Either is reset to the default when
+// null, and the blog is regenerated with them.
+func (b *BlogService) FeedSettingsSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("FeedSettingsSubmit handler...")
+
+	r.MixpanelTrack("FeedSettingsSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+
+	var req struct {
+		Entries     *int32 `json:"entries"`
+		FullContent *bool  `json:"full_content"`
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	params := model.SetFeedSettingsByIDParams{ID: blogID}
+	if req.Entries != nil {
+		if *req.Entries < 1 || *req.Entries > maxFeedEntries {
+			return nil, createCustomError(
+				fmt.Sprintf(
+					"Feeds must have between 1 and %d entries.",
+					maxFeedEntries,
+				),
+				http.StatusBadRequest,
+			)
+		}
+		params.FeedEntries = sql.NullInt32{
+			Int32: *req.Entries, Valid: true,
+		}
+	}
+	if req.FullContent != nil {
+		params.FeedFullContent = sql.NullBool{
+			Bool: *req.FullContent, Valid: true,
+		}
+	}
+
+	if err := b.store.ExecTx(
+		func(tx *model.Store) error {
+			if err := tx.SetFeedSettingsByID(
+				context.TODO(), params,
+			); err != nil {
+				return fmt.Errorf("set feed settings: %w", err)
+			}
+			return regenerateBlog(blogID, tx)
+		},
+	); err != nil {
+		return nil, fmt.Errorf("update feed settings tx: %w", err)
+	}
+
+	return response.NewJson(struct {
+		Message string `json:"message"`
+	}{"Feed settings saved!"})
+}
+
 func (b *BlogService) SetEmailModeSubmit(
 	r request.Request,
 ) (response.Response, error) {
@@ -6,14 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 
 	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
 	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
 	"github.com/hylodoc/hylodoc.com/internal/authn"
@@ -163,6 +169,22 @@ func getTheme(theme string) (model.BlogTheme, error) {
 func UpdateRepositoryOnDisk(
 	c *httpclient.Client, blog *model.Blog,
 	sesh *session.Session, s *model.Store,
+) error {
+	return updateRepositoryOnDisk(c, blog, false, sesh, s)
+}
+
+// UpdateRepositoryOnPush is UpdateRepositoryOnDisk for a push to the live
+// branch, which is ignored if it doesn't change the blog's content root.
+func UpdateRepositoryOnPush(
+	c *httpclient.Client, blog *model.Blog,
+	sesh *session.Session, s *model.Store,
+) error {
+	return updateRepositoryOnDisk(c, blog, true, sesh, s)
+}
+
+func updateRepositoryOnDisk(
+	c *httpclient.Client, blog *model.Blog, onpush bool,
+	sesh *session.Session, s *model.Store,
 ) error {
 	repo, err := s.GetRepositoryByGhRepositoryID(
 		context.TODO(), blog.GhRepositoryID,
@@ -179,72 +201,259 @@ func UpdateRepositoryOnDisk(
 	if err != nil {
 		return fmt.Errorf("access token: %w", err)
 	}
-	h, err := updateAndCheckout(
+	prev := ""
+	if onpush && blog.HeadHash.Valid {
+		prev = blog.HeadHash.String
+	}
+	h, changed, err := updateAndCheckout(
 		repo.Url, repo.GitdirPath, blog.LiveBranch, accessToken,
+		blog.ContentRoot, prev, s,
 	)
 	if err != nil {
 		return fmt.Errorf("update and checkout: %w", err)
 	}
-	if err := s.UpdateBlogLiveHash(
+	if !changed {
+		sesh.Printf(
+			"%s leaves content root `%s' unchanged\n",
+			h, blog.ContentRoot,
+		)
+		return nil
+	}
+	if err := s.UpdateBlogHeadHash(
 		context.TODO(),
-		model.UpdateBlogLiveHashParams{
+		model.UpdateBlogHeadHashParams{
 			ID:       blog.ID,
-			LiveHash: h,
+			HeadHash: h,
 		},
 	); err != nil {
-		return fmt.Errorf("update live hash: %w", err)
+		return fmt.Errorf("update head hash: %w", err)
 	}
+	s.AfterCommit(func() { EnqueueBuild(blog.ID, "") })
 	return nil
 }
 
-// updateAndCheckout clones the repo at the given URL into a bare git dir as
-// provided, and then clones and checks out locally the branch given by its
-// latest hash into config.Config.Hylodoc.CheckoutsPath. It returns this
-// latest hash.
+// updateAndCheckout fetches the branch of the repo at the given URL into the
+// bare git dir as provided, cloning it if it doesn't exist yet, and then checks
+// out the branch's latest hash into config.Config.Hylodoc.CheckoutsPath unless
+// it already is. It returns this latest hash.
+//
+// If prev is set and the directory root of the repository (its content root)
+// is the same at the latest hash as at prev, nothing is checked out and false
+// is returned along with the hash.
 //
-// If gitdir already exists, it is removed before the cloning.
-func updateAndCheckout(repoURL, gitdir, branch, token string) (string, error) {
-	if err := removeDirIfExists(gitdir); err != nil {
-		return "", fmt.Errorf("remove gitdir if exists: %w", err)
+// A git dir that can't be fetched into because its objects, packs or
+// references are unreadable is taken to be corrupted, and is removed and cloned
+// again. Any other error, such as the remote's, is returned as is. Updates of a git
+// dir are serialised across instances, which may share it.
+func updateAndCheckout(
+	repoURL, gitdir, branch, token, root, prev string, s *model.Store,
+) (h string, changed bool, err error) {
+	err = s.WithGitdirLock(gitdir, func() error {
+		var err error
+		h, changed, err = updateAndCheckoutLocked(
+			repoURL, gitdir, branch, token, root, prev,
+		)
+		return err
+	})
+	return h, changed, err
+}
+
+/* updateAndCheckoutLocked is updateAndCheckout with the git dir locked */
+func updateAndCheckoutLocked(
+	repoURL, gitdir, branch, token, root, prev string,
+) (string, bool, error) {
+	h, err := fetchBranch(repoURL, gitdir, branch, token)
+	if err != nil {
+		if !isCorrupt(err) {
+			return "", false, err
+		}
+		log.Printf("recloning %s: %v\n", gitdir, err)
+		if err := removeDirIfExists(gitdir); err != nil {
+			return "", false, fmt.Errorf(
+				"remove gitdir if exists: %w", err,
+			)
+		}
+		if h, err = fetchBranch(repoURL, gitdir, branch, token); err != nil {
+			return "", false, fmt.Errorf("reclone: %w", err)
+		}
 	}
-	refname := plumbing.NewBranchReferenceName(branch)
-	repo, err := git.PlainClone(
-		gitdir,
-		true,
-		&git.CloneOptions{
-			URL: repoURL,
-			Auth: &githttp.BasicAuth{
-				Username: "github", Password: token,
-			},
-			ReferenceName: refname,
-		},
-	)
+	if prev != "" && !contentChanged(gitdir, root, prev, h) {
+		return h, false, nil
+	}
+	if err := checkoutCommit(gitdir, branch, h); err != nil {
+		return "", false, fmt.Errorf("checkout: %w", err)
+	}
+	return h, true, nil
+}
+
+/* contentChanged reports whether the directory root of the repository differs
+ * between the commits, taking it to if that can't be told */
+func contentChanged(gitdir, root, from, to string) bool {
+	if from == to {
+		return false
+	}
+	repo, err := git.PlainOpen(gitdir)
+	if err != nil {
+		return true
+	}
+	fromtree, err := contentTree(repo, root, from)
+	if err != nil {
+		return true
+	}
+	totree, err := contentTree(repo, root, to)
+	if err != nil {
+		return true
+	}
+	return fromtree != totree
+}
+
+/* contentTree returns the hash of the tree of the directory root at the commit,
+ * which identifies its content. A root that is a symlink, or missing, has no
+ * tree. */
+func contentTree(
+	repo *git.Repository, root, hash string,
+) (plumbing.Hash, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("commit: %w", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("tree: %w", err)
+	}
+	if root == "" {
+		return tree.Hash, nil
+	}
+	sub, err := tree.Tree(root)
 	if err != nil {
-		return "", fmt.Errorf("bare clone: %w", err)
+		return plumbing.ZeroHash, fmt.Errorf("%s: %w", root, err)
 	}
+	return sub.Hash, nil
+}
 
+/* fetchBranch fetches the branch into the bare git dir, creating it if need be,
+ * and returns the branch's latest hash. Only config.Config.Git.Depth commits
+ * of it are fetched if that is set. */
+func fetchBranch(repoURL, gitdir, branch, token string) (string, error) {
+	repo, err := git.PlainOpen(gitdir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(gitdir, true)
+	}
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	refname := plumbing.NewBranchReferenceName(branch)
+	/* not saved, so that a renamed repo is fetched from its new URL */
+	remote := git.NewRemote(repo.Storer, &gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+	if err := remote.Fetch(&git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{
+			gitconfig.RefSpec(fmt.Sprintf("+%s:%s", refname, refname)),
+		},
+		Auth: &githttp.BasicAuth{
+			Username: "github", Password: token,
+		},
+		Depth: config.Config.Git.Depth,
+		Tags:  git.NoTags,
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return "", fmt.Errorf("fetch: %w", err)
+	}
 	ref, err := repo.Reference(refname, true)
 	if err != nil {
 		return "", fmt.Errorf("reference: %w", err)
 	}
-	h := ref.Hash().String()
+	/* the fetch finds nothing to do if only the objects are missing */
+	if _, err := repo.CommitObject(ref.Hash()); err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	return ref.Hash().String(), nil
+}
 
-	checkoutdir := filepath.Join(config.Config.Hylodoc.CheckoutsPath, h)
-	if err := removeDirIfExists(checkoutdir); err != nil {
-		return "", fmt.Errorf("remove checkoutdir if exists: %w", err)
+/* isCorrupt reports whether a fetch failed because the git dir couldn't be
+ * read, in which case cloning it again may help, as opposed to the remote (or
+ * the network to it) failing, in which case it wouldn't */
+func isCorrupt(err error) bool {
+	for _, corrupt := range []error{
+		plumbing.ErrObjectNotFound,
+		plumbing.ErrReferenceNotFound,
+		plumbing.ErrInvalidType,
+		dotgit.ErrPackfileNotFound,
+		dotgit.ErrIdxNotFound,
+		dotgit.ErrConfigNotFound,
+		dotgit.ErrPackedRefsBadFormat,
+		dotgit.ErrPackedRefsDuplicatedRef,
+		dotgit.ErrSymRefTargetNotFound,
+		idxfile.ErrMalformedIdxFile,
+		objfile.ErrHeader,
+		objfile.ErrNegativeSize,
+		objfile.ErrOverflow,
+		packfile.ErrReferenceDeltaNotFound,
+		packfile.ErrInvalidDelta,
+		packfile.ErrDeltaCmd,
+	} {
+		if errors.Is(err, corrupt) {
+			return true
+		}
 	}
-	if _, err := git.PlainClone(
-		checkoutdir,
+	/* invalid objects, zlib streams and pack headers, which are reported
+	 * with details and so can't be compared */
+	var packerr *packfile.Error
+	return errors.As(err, &packerr)
+}
+
+// checkoutCommit checks out the given commit of the branch in the bare git dir
+// into config.Config.Hylodoc.CheckoutsPath, unless it is already checked out.
+// The commit must have been fetched.
+func checkoutCommit(gitdir, branch, hash string) error {
+	checkoutdir := filepath.Join(config.Config.Hylodoc.CheckoutsPath, hash)
+	if _, err := os.Stat(checkoutdir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(
+		config.Config.Hylodoc.CheckoutsPath, 0755,
+	); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	/* checked out beside its path and moved there once complete, so that a
+	 * partial checkout is never mistaken for one */
+	tmpdir, err := os.MkdirTemp(
+		config.Config.Hylodoc.CheckoutsPath, "."+hash+"-*",
+	)
+	if err != nil {
+		return fmt.Errorf("temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	repo, err := git.PlainClone(
+		tmpdir,
 		false,
 		&git.CloneOptions{
 			URL:           gitdir,
-			ReferenceName: refname,
+			ReferenceName: plumbing.NewBranchReferenceName(branch),
+			NoCheckout:    true,
 		},
+	)
+	if err != nil {
+		return fmt.Errorf("clone: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("worktree: %w", err)
+	}
+	if err := wt.Checkout(
+		&git.CheckoutOptions{Hash: plumbing.NewHash(hash)},
 	); err != nil {
-		return "", fmt.Errorf("checkout clone: %w", err)
+		return fmt.Errorf("checkout: %w", err)
 	}
-
-	return h, nil
+	if err := os.Rename(tmpdir, checkoutdir); err != nil {
+		/* checked out meanwhile */
+		if _, staterr := os.Stat(checkoutdir); staterr == nil {
+			return nil
+		}
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
 }
 
 func removeDirIfExists(dir string) error {
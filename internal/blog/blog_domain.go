@@ -129,12 +129,19 @@ func (b *BlogService) subdomainSubmit(r request.Request) error {
 		return fmt.Errorf("no blogID")
 	}
 
-	if err := b.store.UpdateBlogSubdomainByID(
-		context.TODO(), model.UpdateBlogSubdomainByIDParams{
-			ID:        blogID,
-			Subdomain: sub,
-		},
-	); err != nil {
+	/* regenerate so that absolute URLs (e.g. in feeds) use the subdomain
+	 * when there's no domain */
+	if err := b.store.ExecTx(func(tx *model.Store) error {
+		if err := tx.UpdateBlogSubdomainByID(
+			context.TODO(), model.UpdateBlogSubdomainByIDParams{
+				ID:        blogID,
+				Subdomain: sub,
+			},
+		); err != nil {
+			return fmt.Errorf("update subdomain: %w", err)
+		}
+		return regenerateBlog(blogID, tx)
+	}); err != nil {
 		if isUniqueSubdomainViolation(err) {
 			return createCustomError(
 				"subdomain already exists",
@@ -186,15 +193,21 @@ func (b *BlogService) DomainSubmit(
 		)
 	}
 
-	if err := b.store.UpdateBlogDomainByID(
-		context.TODO(), model.UpdateBlogDomainByIDParams{
-			ID: blogID,
-			Domain: wrapNullString(
-				strings.TrimSpace(strings.ToLower(domain)),
-			),
-		},
-	); err != nil {
-		return nil, fmt.Errorf("update domain: %w", err)
+	/* regenerate so that absolute URLs (e.g. in feeds) use the domain */
+	if err := b.store.ExecTx(func(tx *model.Store) error {
+		if err := tx.UpdateBlogDomainByID(
+			context.TODO(), model.UpdateBlogDomainByIDParams{
+				ID: blogID,
+				Domain: wrapNullString(
+					strings.TrimSpace(strings.ToLower(domain)),
+				),
+			},
+		); err != nil {
+			return fmt.Errorf("update domain: %w", err)
+		}
+		return regenerateBlog(blogID, tx)
+	}); err != nil {
+		return nil, fmt.Errorf("update domain tx: %w", err)
 	}
 	return response.NewRedirect(
 		fmt.Sprintf(
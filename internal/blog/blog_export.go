@@ -0,0 +1,269 @@
+package blog
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+const (
+	/* days exported when no range is given */
+	defaultExportDays = 30
+	/* most days exported at once */
+	maxExportDays = 366
+)
+
+type exportday struct {
+	Date           string       `json:"date"` /* "YYYY-MM-DD", UTC */
+	Views          int          `json:"views"`
+	Visitors       int          `json:"visitors"`
+	EmailClicks    int          `json:"email_clicks"`
+	NewSubscribers int          `json:"new_subscribers"`
+	Unsubscribes   int          `json:"unsubscribes"`
+	Subscribers    int          `json:"subscribers"`
+	Posts          []exportpost `json:"posts"`
+}
+
+type exportpost struct {
+	Url         string `json:"url"`
+	Views       int    `json:"views"`
+	Visitors    int    `json:"visitors"`
+	EmailClicks int    `json:"email_clicks"`
+}
+
+// ExportMetrics downloads the blog's daily views, email clicks and subscriber
+// growth, in total and per post, between the from and to query parameters
+// (inclusive, "YYYY-MM-DD", at most maxExportDays apart) as CSV or, with
+// format=json, JSON.
+//
+// Email clicks are only dated since clicked_at was added, so clicks before
+// then are not exported. Likewise subscribers who unsubscribed before
+// unsubscribed_at was added are left out of every day's count.
+func (b *BlogService) ExportMetrics(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("ExportMetrics handler...")
+
+	r.MixpanelTrack("ExportMetrics")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	if err := b.requireAnalytics(sesh); err != nil {
+		return nil, err
+	}
+	from, to, err := parseExportRange(
+		r.GetURLQueryValue("from"), r.GetURLQueryValue("to"),
+	)
+	if err != nil {
+		return nil, createCustomError(err.Error(), http.StatusBadRequest)
+	}
+	days, err := b.exportMetrics(blogID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	switch format := r.GetURLQueryValue("format"); format {
+	case "json":
+		return response.NewJson(struct {
+			From string      `json:"from"`
+			To   string      `json:"to"`
+			Days []exportday `json:"days"`
+		}{from.Format(time.DateOnly), to.Format(time.DateOnly), days})
+	case "", "csv":
+		csv, err := buildMetricsCSV(days)
+		if err != nil {
+			return nil, fmt.Errorf("build csv: %w", err)
+		}
+		return response.NewCsvFile(
+			fmt.Sprintf(
+				"metrics-%s-%s.csv",
+				from.Format(time.DateOnly), to.Format(time.DateOnly),
+			),
+			csv,
+		), nil
+	default:
+		return nil, createCustomError(
+			fmt.Sprintf("unknown format %q", format),
+			http.StatusBadRequest,
+		)
+	}
+}
+
+/* parseExportRange defaults to the last defaultExportDays days */
+func parseExportRange(rawfrom, rawto string) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if rawto != "" {
+		t, err := time.Parse(time.DateOnly, rawto)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to")
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -(defaultExportDays - 1))
+	if rawfrom != "" {
+		t, err := time.Parse(time.DateOnly, rawfrom)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from")
+		}
+		from = t
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from after to")
+	}
+	if to.Sub(from) >= maxExportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf(
+			"at most %d days can be exported at once", maxExportDays,
+		)
+	}
+	return from, to, nil
+}
+
+func (b *BlogService) exportMetrics(
+	blogID string, from, to time.Time,
+) ([]exportday, error) {
+	var days []exportday
+	index := map[string]int{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		index[date] = len(days)
+		days = append(days, exportday{Date: date, Posts: []exportpost{}})
+	}
+	posts := map[string]map[string]*exportpost{}
+	post := func(day time.Time, url string) *exportpost {
+		date := day.Format(time.DateOnly)
+		if posts[date] == nil {
+			posts[date] = map[string]*exportpost{}
+		}
+		if posts[date][url] == nil {
+			posts[date][url] = &exportpost{Url: url}
+		}
+		return posts[date][url]
+	}
+
+	blogrows, err := b.store.ListDailyBlogVisitsBetween(
+		context.TODO(),
+		model.ListDailyBlogVisitsBetweenParams{
+			Blog: blogID, FromDay: from, ToDay: to,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("blog visits: %w", err)
+	}
+	for _, row := range blogrows {
+		d := &days[index[row.Day.Format(time.DateOnly)]]
+		d.Views, d.Visitors = int(row.Views), int(row.Uniques)
+	}
+	postrows, err := b.store.ListDailyPostVisitsBetween(
+		context.TODO(),
+		model.ListDailyPostVisitsBetweenParams{
+			Blog: blogID, FromDay: from, ToDay: to,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("post visits: %w", err)
+	}
+	for _, row := range postrows {
+		p := post(row.Day, row.Url)
+		p.Views, p.Visitors = int(row.Views), int(row.Uniques)
+	}
+	clickrows, err := b.store.ListDailyEmailClicksBetween(
+		context.TODO(),
+		model.ListDailyEmailClicksBetweenParams{
+			Blog: blogID, FromDay: from, ToDay: to,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("email clicks: %w", err)
+	}
+	for _, row := range clickrows {
+		post(row.Day, row.Url).EmailClicks = int(row.Clicks)
+		days[index[row.Day.Format(time.DateOnly)]].EmailClicks += int(row.Clicks)
+	}
+
+	subs, err := b.store.ListSubscriptionsByBlogID(context.TODO(), blogID)
+	if err != nil {
+		return nil, fmt.Errorf("list subscriptions: %w", err)
+	}
+	before := 0
+	for _, sub := range subs {
+		day := sub.CreatedAt.UTC().Truncate(24 * time.Hour)
+		if day.Before(from) {
+			before++
+		} else if i, ok := index[day.Format(time.DateOnly)]; ok {
+			days[i].NewSubscribers++
+		}
+		if !sub.UnsubscribedAt.Valid {
+			continue
+		}
+		day = sub.UnsubscribedAt.Time.UTC().Truncate(24 * time.Hour)
+		if day.Before(from) {
+			before--
+		} else if i, ok := index[day.Format(time.DateOnly)]; ok {
+			days[i].Unsubscribes++
+		}
+	}
+	total := before
+	for i := range days {
+		total += days[i].NewSubscribers - days[i].Unsubscribes
+		days[i].Subscribers = total
+		for _, p := range posts[days[i].Date] {
+			days[i].Posts = append(days[i].Posts, *p)
+		}
+		sort.Slice(days[i].Posts, func(j, k int) bool {
+			return days[i].Posts[j].Url < days[i].Posts[k].Url
+		})
+	}
+	return days, nil
+}
+
+/* buildMetricsCSV writes a row for the blog (with an empty url) and a row for
+ * each post that has data on each day */
+func buildMetricsCSV(days []exportday) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	fields := []string{
+		"Date", "Url", "Views", "Visitors", "EmailClicks",
+		"NewSubscribers", "Unsubscribes", "Subscribers",
+	}
+	if err := writer.Write(fields); err != nil {
+		return nil, fmt.Errorf("error writing header: %w", err)
+	}
+	for _, d := range days {
+		if err := writer.Write([]string{
+			d.Date, "",
+			strconv.Itoa(d.Views),
+			strconv.Itoa(d.Visitors),
+			strconv.Itoa(d.EmailClicks),
+			strconv.Itoa(d.NewSubscribers),
+			strconv.Itoa(d.Unsubscribes),
+			strconv.Itoa(d.Subscribers),
+		}); err != nil {
+			return nil, fmt.Errorf("error writing row: %w", err)
+		}
+		for _, p := range d.Posts {
+			if err := writer.Write([]string{
+				d.Date, p.Url,
+				strconv.Itoa(p.Views),
+				strconv.Itoa(p.Visitors),
+				strconv.Itoa(p.EmailClicks),
+				"", "", "",
+			}); err != nil {
+				return nil, fmt.Errorf("error writing row: %w", err)
+			}
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/hylodoc/hylodoc.com/internal/config"
@@ -27,12 +28,24 @@ type BlogInfo struct {
 	Theme                    string
 	Status                   string
 	LiveBranch               string
+	ContentRoot              string
+	PreviewBranches          string
 	UpdatedAt                time.Time
 	IsLive                   bool
+	IsBuilding               bool
+	LastBuildFailed          bool
+	IsPinned                 bool
+	OfflineMessage           string
+	StatsUrl                 string
+	FeedEntries              string /* empty for the default */
+	DefaultFeedEntries       int
+	FeedContent              string /* "full", "summary" or "" for default */
+	DefaultFeedFullContent   bool
 	IsEmailModeHtml          bool
 	Hash                     string
 	HashUrl                  string
 	SyncUrl                  string
+	BuildsUrl                string
 	DeleteMessage            string
 }
 
@@ -135,6 +148,13 @@ func buildSyncUrl(blogID string) string {
 	)
 }
 
+func buildBuildsUrl(blogID string) string {
+	return fmt.Sprintf(
+		"/user/blogs/%s/builds",
+		blogID,
+	)
+}
+
 func getBlogInfo(s *model.Store, blogID string) (BlogInfo, error) {
 	blog, err := s.GetBlogByID(context.TODO(), blogID)
 	if err != nil {
@@ -152,6 +172,18 @@ func getBlogInfo(s *model.Store, blogID string) (BlogInfo, error) {
 	if err != nil {
 		return BlogInfo{}, fmt.Errorf("ghurl: %w", err)
 	}
+	last, err := s.ListBuildsByBlog(
+		context.TODO(),
+		model.ListBuildsByBlogParams{Blog: blogID, Limit: 1},
+	)
+	if err != nil {
+		return BlogInfo{}, fmt.Errorf("last build: %w", err)
+	}
+	lastFailed := len(last) > 0 && last[0].Status == model.BuildStatusFailed
+	isBuilding, err := IsBuilding(blogID, s)
+	if err != nil {
+		return BlogInfo{}, fmt.Errorf("is building: %w", err)
+	}
 	return BlogInfo{
 		ID:                       blog.ID,
 		Name:                     getname(&blog),
@@ -166,11 +198,23 @@ func getBlogInfo(s *model.Store, blogID string) (BlogInfo, error) {
 		ConfigUrl:                buildConfigUrl(blog.ID),
 		DeleteUrl:                buildDeleteUrl(blog.ID),
 		LiveBranch:               blog.LiveBranch,
+		ContentRoot:              blog.ContentRoot,
+		PreviewBranches:          blog.PreviewBranches,
 		Theme:                    string(blog.Theme),
 		UpdatedAt:                blog.UpdatedAt,
 		IsLive:                   isLive,
+		IsBuilding:               isBuilding,
+		LastBuildFailed:          lastFailed,
+		IsPinned:                 blog.Pinned,
+		OfflineMessage:           blog.OfflineMessage.String,
+		StatsUrl:                 buildStatsUrl(blog.StatsToken),
+		FeedEntries:              feedEntries(&blog),
+		DefaultFeedEntries:       config.Config.Feeds.Entries,
+		FeedContent:              feedContent(&blog),
+		DefaultFeedFullContent:   config.Config.Feeds.FullContent,
 		IsEmailModeHtml:          isEmailModeHtml,
 		SyncUrl:                  buildSyncUrl(blog.ID),
+		BuildsUrl:                buildBuildsUrl(blog.ID),
 		Hash:                     blog.LiveHash.String,
 		HashUrl: ghurl.JoinPath(
 			"commit", blog.LiveHash.String,
@@ -179,6 +223,24 @@ func getBlogInfo(s *model.Store, blogID string) (BlogInfo, error) {
 	}, nil
 }
 
+func feedEntries(b *model.Blog) string {
+	if !b.FeedEntries.Valid {
+		return ""
+	}
+	return strconv.Itoa(int(b.FeedEntries.Int32))
+}
+
+func feedContent(b *model.Blog) string {
+	switch {
+	case !b.FeedFullContent.Valid:
+		return ""
+	case b.FeedFullContent.Bool:
+		return "full"
+	default:
+		return "summary"
+	}
+}
+
 func blogEmailModeIsHtml(emailMode model.EmailMode) (bool, error) {
 	switch emailMode {
 	case model.EmailModeHtml:
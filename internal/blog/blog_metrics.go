@@ -21,6 +21,9 @@ import (
 
 type SiteData struct {
 	CumulativeCounts template.JS
+	Views            int
+	Visitors         int
+	Sources          *sourcedata
 }
 
 func (b *BlogService) SiteMetrics(
@@ -44,6 +47,14 @@ func (b *BlogService) SiteMetrics(
 	if err != nil {
 		return nil, fmt.Errorf("get site metrics: %w", err)
 	}
+	counts, err := b.store.CountBlogVisits(context.TODO(), blog.ID)
+	if err != nil {
+		return nil, fmt.Errorf("count visits: %w", err)
+	}
+	sources, err := b.getSources(blog.ID, sql.NullString{})
+	if err != nil {
+		return nil, fmt.Errorf("get sources: %w", err)
+	}
 	userID, err := sesh.GetUserID()
 	if err != nil {
 		return nil, fmt.Errorf("get user id: %w", err)
@@ -56,7 +67,7 @@ func (b *BlogService) SiteMetrics(
 	}
 
 	return response.NewTemplate(
-		[]string{"site_metrics.html", "posts.html"},
+		[]string{"site_metrics.html", "posts.html", "sources.html"},
 		util.PageInfo{
 			Data: struct {
 				Title    string
@@ -68,10 +79,15 @@ func (b *BlogService) SiteMetrics(
 				CanViewAnalyticsAndSendEmails bool
 				UpgradeURL                    string
 			}{
-				Title:                         "Dashboard",
-				SiteName:                      getsitename(&blog),
-				IsLive:                        blog.IsLive,
-				UserInfo:                      session.ConvertSessionToUserInfo(sesh),
+				Title:    "Dashboard",
+				SiteName: getsitename(&blog),
+				IsLive:   blog.IsLive,
+				UserInfo: session.ConvertSessionToUserInfo(sesh),
+				SiteData: SiteData{
+					Views:    int(counts.Views),
+					Visitors: int(counts.Visitors),
+					Sources:  sources,
+				},
 				PostData:                      data,
 				CanViewAnalyticsAndSendEmails: canView,
 				UpgradeURL: fmt.Sprintf(
@@ -85,14 +101,19 @@ func (b *BlogService) SiteMetrics(
 }
 
 type postdata struct {
-	Title   string
-	Url     template.URL
-	Date    *time.Time
-	NewSubs int
-	Views   int
-	Email   emaildata.EmailData
+	Title    string
+	Path     string
+	Url      template.URL
+	Date     *time.Time
+	NewSubs  int
+	Views    int
+	Visitors int
+	Email    emaildata.EmailData
+	Sources  *sourcedata
 }
 
+/* getSiteMetrics returns the metrics of each of the blog's active posts, with
+ * a query per metric for all of them rather than for each post */
 func (b *BlogService) getSiteMetrics(blogid string) ([]postdata, error) {
 	blog, err := b.store.GetBlogByID(context.TODO(), blogid)
 	if err != nil {
@@ -102,6 +123,26 @@ func (b *BlogService) getSiteMetrics(blogid string) ([]postdata, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot list posts: %w", err)
 	}
+	counts, err := b.store.ListPostVisitCounts(context.TODO(), blogid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot count visits: %w", err)
+	}
+	visits := map[string]model.ListPostVisitCountsRow{}
+	for _, c := range counts {
+		visits[c.Url] = c
+	}
+	clickcounts, err := b.store.ListEmailClickCounts(context.TODO(), blogid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot count clicks: %w", err)
+	}
+	clicks := map[string]int{}
+	for _, c := range clickcounts {
+		clicks[c.Url] = int(c.Clicks)
+	}
+	sources, err := b.getPostSources(blogid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get sources: %w", err)
+	}
 	data := make([]postdata, len(posts))
 	for i, p := range posts {
 		u, err := url.JoinPath(
@@ -116,37 +157,145 @@ func (b *BlogService) getSiteMetrics(blogid string) ([]postdata, error) {
 		if err != nil {
 			return nil, fmt.Errorf("url error: %w", err)
 		}
-		visits, err := b.store.CountVisits(
-			context.TODO(),
-			model.CountVisitsParams{
-				Blog: blogid,
-				Url:  p.Url,
-			},
-		)
-		if err != nil {
-			return nil, fmt.Errorf("cannot count visits: %w", err)
-		}
-		emailopens, err := b.store.CountEmailClicks(
-			context.TODO(),
-			model.CountEmailClicksParams{
-				Blog: blogid,
-				Url:  p.Url,
-			},
-		)
-		if err != nil {
-			return nil, fmt.Errorf("cannot count clicks: %w", err)
+		postsources, ok := sources[p.Url]
+		if !ok {
+			postsources = &sourcedata{}
 		}
 		data[i] = postdata{
-			Title: p.Title,
-			Url:   template.URL(u),
-			Date:  unwrapsqltime(p.PublishedAt),
-			Views: int(visits),
-			Email: getemaildata(&posts[i], int(emailopens)),
+			Title:    p.Title,
+			Path:     p.Url,
+			Url:      template.URL(u),
+			Date:     unwrapsqltime(p.PublishedAt),
+			Views:    int(visits[p.Url].Views),
+			Visitors: int(visits[p.Url].Visitors),
+			Email:    getemaildata(&posts[i], clicks[p.Url]),
+			Sources:  postsources,
 		}
 	}
 	return data, nil
 }
 
+/* number of rows in each of the top-referrer and top-campaign tables */
+const topSources = 10
+
+/* where readers of a blog, or of one of its posts, come from */
+type sourcedata struct {
+	Referrers []referrerdata
+	Campaigns []campaigndata
+}
+
+type referrerdata struct {
+	Host  string
+	Views int
+}
+
+type campaigndata struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Views    int
+}
+
+func (s *sourcedata) IsEmpty() bool {
+	return len(s.Referrers) == 0 && len(s.Campaigns) == 0
+}
+
+/* getSources returns the top sources of visits to the blog, or only to the
+ * post at url if it is valid */
+func (b *BlogService) getSources(
+	blogid string, url sql.NullString,
+) (*sourcedata, error) {
+	referrers, err := b.store.ListTopReferrers(
+		context.TODO(),
+		model.ListTopReferrersParams{
+			Blog:  blogid,
+			Url:   url,
+			Count: topSources,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("referrers: %w", err)
+	}
+	campaigns, err := b.store.ListTopCampaigns(
+		context.TODO(),
+		model.ListTopCampaignsParams{
+			Blog:  blogid,
+			Url:   url,
+			Count: topSources,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("campaigns: %w", err)
+	}
+	var data sourcedata
+	for _, r := range referrers {
+		data.Referrers = append(data.Referrers, referrerdata{
+			Host:  r.Referrer,
+			Views: int(r.Views),
+		})
+	}
+	for _, c := range campaigns {
+		data.Campaigns = append(data.Campaigns, campaigndata{
+			Source:   c.UtmSource,
+			Medium:   c.UtmMedium,
+			Campaign: c.UtmCampaign,
+			Views:    int(c.Views),
+		})
+	}
+	return &data, nil
+}
+
+/* getPostSources returns the top sources of visits to each of the blog's
+ * posts, by URL */
+func (b *BlogService) getPostSources(
+	blogid string,
+) (map[string]*sourcedata, error) {
+	referrers, err := b.store.ListTopReferrersByPost(
+		context.TODO(),
+		model.ListTopReferrersByPostParams{
+			Blog:  blogid,
+			Count: topSources,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("referrers: %w", err)
+	}
+	campaigns, err := b.store.ListTopCampaignsByPost(
+		context.TODO(),
+		model.ListTopCampaignsByPostParams{
+			Blog:  blogid,
+			Count: topSources,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("campaigns: %w", err)
+	}
+	data := map[string]*sourcedata{}
+	get := func(url string) *sourcedata {
+		if _, ok := data[url]; !ok {
+			data[url] = &sourcedata{}
+		}
+		return data[url]
+	}
+	for _, r := range referrers {
+		d := get(r.Url)
+		d.Referrers = append(d.Referrers, referrerdata{
+			Host:  r.Referrer,
+			Views: int(r.Views),
+		})
+	}
+	for _, c := range campaigns {
+		d := get(c.Url)
+		d.Campaigns = append(d.Campaigns, campaigndata{
+			Source:   c.UtmSource,
+			Medium:   c.UtmMedium,
+			Campaign: c.UtmCampaign,
+			Views:    int(c.Views),
+		})
+	}
+	return data, nil
+}
+
 func getemaildata(post *model.Post, clicks int) emaildata.EmailData {
 	if post.EmailSent {
 		return emaildata.NewSent(clicks)
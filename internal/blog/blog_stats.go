@@ -0,0 +1,209 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
+	"github.com/hylodoc/hylodoc.com/internal/authz"
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/session"
+	"github.com/hylodoc/hylodoc.com/internal/util"
+)
+
+/* number of posts listed on the public stats page */
+const publicTopPosts = 10
+
+// SetPublicStatsSubmit turns the blog's public stats page on or off. Turning
+// it off revokes its URL; turning it back on creates a new one.
+func (b *BlogService) SetPublicStatsSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("SetPublicStatsSubmit handler...")
+
+	r.MixpanelTrack("SetPublicStatsSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	if err := b.requireAnalytics(sesh); err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Public bool `json:"public"`
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+
+	blog, err := b.store.GetBlogByID(context.TODO(), blogID)
+	if err != nil {
+		return nil, fmt.Errorf("get blog: %w", err)
+	}
+	token := blog.StatsToken
+	switch {
+	case req.Public && !token.Valid:
+		token = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	case !req.Public:
+		token = uuid.NullUUID{}
+	}
+	if err := b.store.SetBlogStatsToken(
+		context.TODO(),
+		model.SetBlogStatsTokenParams{StatsToken: token, ID: blogID},
+	); err != nil {
+		return nil, fmt.Errorf("set stats token: %w", err)
+	}
+
+	type resp struct {
+		Message string `json:"message"`
+		Url     string `json:"url,omitempty"`
+	}
+	if !token.Valid {
+		return response.NewJson(&resp{Message: "Stats page is now private."})
+	}
+	return response.NewJson(&resp{
+		Message: "Stats page is now public.",
+		Url:     buildStatsUrl(token),
+	})
+}
+
+type publicpost struct {
+	Title string
+	Url   template.URL
+	Date  *time.Time
+	Views int
+}
+
+// PublicStats is the read-only stats page of a blog that has opted in. It
+// shows totals and top posts only: nothing about individual readers,
+// subscribers or referrers.
+func (b *BlogService) PublicStats(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("PublicStats handler...")
+
+	r.MixpanelTrack("PublicStats")
+
+	token, ok := r.GetRouteVar("token")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	parsed, err := uuid.Parse(token)
+	if err != nil {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	blog, err := b.store.GetBlogByStatsToken(
+		context.TODO(), uuid.NullUUID{UUID: parsed, Valid: true},
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, createCustomError("", http.StatusNotFound)
+		}
+		return nil, fmt.Errorf("get blog: %w", err)
+	}
+	/* the page goes away with the owner's analytics */
+	canView, err := authz.HasAnalyticsCustomDomainsImagesEmails(
+		b.store, blog.UserID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can view analytics: %w", err)
+	}
+	if !canView {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	counts, err := b.store.CountBlogVisits(context.TODO(), blog.ID)
+	if err != nil {
+		return nil, fmt.Errorf("count visits: %w", err)
+	}
+	subs, err := b.store.ListActiveSubscribersByBlogID(
+		context.TODO(), blog.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list subscribers: %w", err)
+	}
+	top, err := b.store.ListTopPostsByViews(
+		context.TODO(),
+		model.ListTopPostsByViewsParams{
+			Blog:  blog.ID,
+			Count: publicTopPosts,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list top posts: %w", err)
+	}
+	posts := make([]publicpost, len(top))
+	for i, p := range top {
+		u, err := url.JoinPath(
+			fmt.Sprintf(
+				"%s://%s.%s",
+				config.Config.Hylodoc.Protocol,
+				blog.Subdomain,
+				config.Config.Hylodoc.RootDomain,
+			),
+			p.Url,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("url error: %w", err)
+		}
+		posts[i] = publicpost{
+			Title: p.Title,
+			Url:   template.URL(u),
+			Date:  unwrapsqltime(p.PublishedAt),
+			Views: int(p.Views),
+		}
+	}
+
+	return response.NewTemplate(
+		[]string{"stats_public.html"},
+		util.PageInfo{
+			Data: struct {
+				Title       string
+				UserInfo    *session.UserInfo
+				SiteName    string
+				Views       int
+				Visitors    int
+				Subscribers int
+				Posts       []publicpost
+			}{
+				Title:       fmt.Sprintf("%s – Stats", getsitename(&blog)),
+				UserInfo:    session.ConvertSessionToUserInfo(sesh),
+				SiteName:    getsitename(&blog),
+				Views:       int(counts.Views),
+				Visitors:    int(counts.Visitors),
+				Subscribers: len(subs),
+				Posts:       posts,
+			},
+		},
+	), nil
+}
+
+/* buildStatsUrl returns "" if the stats page isn't public */
+func buildStatsUrl(token uuid.NullUUID) string {
+	if !token.Valid {
+		return ""
+	}
+	return fmt.Sprintf(
+		"%s://%s/stats/%s",
+		config.Config.Hylodoc.Protocol,
+		config.Config.Hylodoc.RootDomain,
+		token.UUID,
+	)
+}
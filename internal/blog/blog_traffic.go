@@ -0,0 +1,191 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
+	"github.com/hylodoc/hylodoc.com/internal/authz"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/session"
+)
+
+/* days covered by each range a chart can show; 0 is all time */
+var trafficRanges = map[string]int{
+	"7d":  7,
+	"30d": 30,
+	"90d": 90,
+	"all": 0,
+}
+
+const (
+	intervalDay  = "day"
+	intervalWeek = "week"
+)
+
+type trafficpoint struct {
+	Timestamp string `json:"timestamp"` /* first day of the interval, "YYYY-MM-DD" */
+	Views     int    `json:"views"`
+	Visitors  int    `json:"visitors"` /* counted once per day */
+}
+
+/* a day's rollup of the visits to a blog or post */
+type dailyvisits struct {
+	day      time.Time
+	views    int
+	visitors int
+}
+
+// TrafficMetrics returns the views of the blog, or of the post at the url
+// query parameter, as a series of daily or weekly points over a range.
+func (b *BlogService) TrafficMetrics(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("TrafficMetrics handler...")
+
+	r.MixpanelTrack("TrafficMetrics")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	if err := b.requireAnalytics(sesh); err != nil {
+		return nil, err
+	}
+
+	rng := r.GetURLQueryValue("range")
+	if rng == "" {
+		rng = "30d"
+	}
+	ndays, ok := trafficRanges[rng]
+	if !ok {
+		return nil, createCustomError("invalid range", http.StatusBadRequest)
+	}
+	interval := r.GetURLQueryValue("interval")
+	if interval == "" {
+		interval = intervalDay
+	}
+	if interval != intervalDay && interval != intervalWeek {
+		return nil, createCustomError(
+			"invalid interval", http.StatusBadRequest,
+		)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := time.Time{}
+	if ndays > 0 {
+		since = today.AddDate(0, 0, -(ndays - 1))
+	}
+	days, err := b.listDailyVisits(
+		blogID, r.GetURLQueryValue("url"), since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list daily visits: %w", err)
+	}
+	if since.IsZero() {
+		since = today
+		if len(days) > 0 {
+			since = days[0].day
+		}
+	}
+	return response.NewJson(struct {
+		Range    string         `json:"range"`
+		Interval string         `json:"interval"`
+		Points   []trafficpoint `json:"points"`
+	}{rng, interval, buildTrafficSeries(days, since, today, interval)})
+}
+
+/* listDailyVisits returns the daily visits to the blog, or to the post at url
+ * if it isn't empty, from since onwards in order */
+func (b *BlogService) listDailyVisits(
+	blogID, url string, since time.Time,
+) ([]dailyvisits, error) {
+	var days []dailyvisits
+	if url == "" {
+		rows, err := b.store.ListDailyBlogVisits(
+			context.TODO(),
+			model.ListDailyBlogVisitsParams{Blog: blogID, Since: since},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("blog: %w", err)
+		}
+		for _, row := range rows {
+			days = append(days, dailyvisits{
+				row.Day, int(row.Views), int(row.Uniques),
+			})
+		}
+		return days, nil
+	}
+	rows, err := b.store.ListDailyPostVisits(
+		context.TODO(),
+		model.ListDailyPostVisitsParams{
+			Blog:  blogID,
+			Url:   url,
+			Since: since,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("post: %w", err)
+	}
+	for _, row := range rows {
+		days = append(days, dailyvisits{
+			row.Day, int(row.Views), int(row.Uniques),
+		})
+	}
+	return days, nil
+}
+
+/* buildTrafficSeries has a point for every day (or week, starting on Monday)
+ * from since to today, including those without visits */
+func buildTrafficSeries(
+	days []dailyvisits, since, today time.Time, interval string,
+) []trafficpoint {
+	byday := map[time.Time]dailyvisits{}
+	for _, d := range days {
+		byday[d.day.UTC().Truncate(24*time.Hour)] = d
+	}
+	points := []trafficpoint{}
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		start := day
+		if interval == intervalWeek {
+			start = startOfWeek(day)
+		}
+		timestamp := start.Format(time.DateOnly)
+		if n := len(points); n == 0 || points[n-1].Timestamp != timestamp {
+			points = append(points, trafficpoint{Timestamp: timestamp})
+		}
+		d := byday[day]
+		points[len(points)-1].Views += d.views
+		points[len(points)-1].Visitors += d.visitors
+	}
+	return points
+}
+
+func startOfWeek(day time.Time) time.Time {
+	/* Sunday is 0 */
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+/* requireAnalytics fails with authz.SubscriptionError unless the user's plan
+ * includes analytics */
+func (b *BlogService) requireAnalytics(sesh *session.Session) error {
+	userID, err := sesh.GetUserID()
+	if err != nil {
+		return fmt.Errorf("get user id: %w", err)
+	}
+	canView, err := authz.HasAnalyticsCustomDomainsImagesEmails(
+		b.store, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("can view analytics: %w", err)
+	}
+	if !canView {
+		return authz.SubscriptionError
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+/*
+ * Blogs are generated in the background by a pool of workers so that no
+ * request waits for the SSG: readers are served the latest completed
+ * generation while a fresh one is built.
+ */
+
+// ErrNotGenerated is returned for a blog (or preview) that is yet to complete
+// its first generation.
+var ErrNotGenerated = errors.New("not generated")
+
+/* a build of the live site of a blog, or of its preview if label is set */
+type buildjob struct{ blog, label string }
+
+type buildstate int
+
+const (
+	buildQueued buildstate = iota
+	buildRunning
+	/* running, and to be queued again because the blog changed meanwhile */
+	buildRerun
+)
+
+var builds = struct {
+	mu    sync.Mutex
+	queue chan buildjob
+	state map[buildjob]buildstate
+	/* the boot the builds are recorded under */
+	bootid  int32
+	stopped bool
+	workers sync.WaitGroup
+}{state: map[buildjob]buildstate{}}
+
+// StartBuilders starts n workers that generate the blogs queued with
+// EnqueueBuild, recording their builds under the given boot. At most queueSize
+// blogs wait to be generated; further ones are dropped and queued again on
+// their next request.
+func StartBuilders(s *model.Store, bootid int32, n, queueSize int) error {
+	if n <= 0 {
+		return fmt.Errorf("no workers")
+	}
+	if queueSize <= 0 {
+		return fmt.Errorf("no queue")
+	}
+	builds.mu.Lock()
+	builds.queue = make(chan buildjob, queueSize)
+	builds.bootid = bootid
+	builds.mu.Unlock()
+	for i := 0; i < n; i++ {
+		builds.workers.Add(1)
+		go runBuilder(builds.queue, s)
+	}
+	return nil
+}
+
+func bootid() int32 {
+	builds.mu.Lock()
+	defer builds.mu.Unlock()
+	return builds.bootid
+}
+
+// StopBuilders stops queueing builds and waits for those running to finish.
+// The builds still queued are dropped, to be queued again on their next
+// request.
+func StopBuilders() {
+	builds.mu.Lock()
+	if !builds.stopped && builds.queue != nil {
+		builds.stopped = true
+		close(builds.queue)
+	}
+	builds.mu.Unlock()
+	builds.workers.Wait()
+}
+
+// EnqueueBuild queues a generation of the blog, or of its preview with the
+// given label if it isn't empty. Nothing is queued if the blog is already
+// queued, and a blog being built is queued again once its build finishes.
+func EnqueueBuild(blogID, label string) {
+	job := buildjob{blogID, label}
+	builds.mu.Lock()
+	defer builds.mu.Unlock()
+	if builds.stopped {
+		return
+	}
+	state, ok := builds.state[job]
+	if ok {
+		if state == buildRunning {
+			builds.state[job] = buildRerun
+		}
+		return
+	}
+	select {
+	case builds.queue <- job:
+		builds.state[job] = buildQueued
+	default:
+		log.Printf("build queue full, dropping %s %q\n", blogID, label)
+	}
+}
+
+// IsBuilding reports whether the live site of the blog is being built on any
+// instance.
+func IsBuilding(blogID string, s *model.Store) (bool, error) {
+	return s.IsBlogBuilding(context.TODO(), blogID)
+}
+
+func runBuilder(queue <-chan buildjob, s *model.Store) {
+	defer builds.workers.Done()
+	for job := range queue {
+		builds.mu.Lock()
+		if builds.stopped {
+			delete(builds.state, job)
+			builds.mu.Unlock()
+			continue
+		}
+		builds.state[job] = buildRunning
+		builds.mu.Unlock()
+
+		if err := build(job, s); err != nil {
+			log.Printf("build %s %q: %v\n", job.blog, job.label, err)
+		}
+
+		builds.mu.Lock()
+		rerun := builds.state[job] == buildRerun
+		delete(builds.state, job)
+		builds.mu.Unlock()
+		if rerun {
+			EnqueueBuild(job.blog, job.label)
+		}
+	}
+}
+
+/* build generates the job. A job runs on one worker of the process at a time,
+ * and across instances (and the jobs of a blog's previews) builds of the blog
+ * are serialised by an advisory lock, so whoever waited on it finds the
+ * generation fresh and doesn't generate it again. */
+func build(job buildjob, s *model.Store) error {
+	return s.WithBlogBuildLock(job.blog, func() error {
+		_, err := generateJob(job, s)
+		return err
+	})
+}
+
+func generateJob(job buildjob, s *model.Store) (int32, error) {
+	if job.label != "" {
+		return generatePreview(job.blog, job.label, s)
+	}
+	return generate(job.blog, s)
+}
+
+// LatestGeneration returns the fresh generation of the blog, or of its preview
+// with the given label if it isn't empty. If there is none it returns the
+// latest generation that has completed, queueing a build unless one of the
+// blog's current commit, theme and content root is underway or has failed.
+// Failed builds are retried on push, sync or a change of configuration.
+func LatestGeneration(blogid, label string, s *model.Store) (int32, error) {
+	gen, err := freshGeneration(blogid, label, s)
+	if err == nil {
+		return gen, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return -1, fmt.Errorf("fresh: %w", err)
+	}
+	if err := enqueueIfUnbuilt(blogid, label, s); err != nil {
+		return -1, fmt.Errorf("enqueue if unbuilt: %w", err)
+	}
+	gen, err = s.GetLatestGeneration(
+		context.TODO(),
+		model.GetLatestGenerationParams{
+			Blog:  sql.NullString{String: blogid, Valid: true},
+			Label: label,
+		},
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return -1, ErrNotGenerated
+		}
+		return -1, fmt.Errorf("latest: %w", err)
+	}
+	return gen, nil
+}
+
+/* enqueueIfStale is enqueueIfUnbuilt for a blog without a fresh generation */
+func enqueueIfStale(blogid string, s *model.Store) error {
+	if _, err := freshGeneration(blogid, "", s); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return enqueueIfUnbuilt(blogid, "", s)
+	}
+	return nil
+}
+
+/* enqueueIfUnbuilt queues a build unless the latest build of the blog's
+ * current commit, theme and content root is underway or has failed. An
+ * interrupted build is queued again. It is for
+ * callers that have found the blog stale and would otherwise queue the same
+ * failing build on every request. */
+func enqueueIfUnbuilt(blogid, label string, s *model.Store) error {
+	status, err := s.GetCurrentBuildStatus(
+		context.TODO(),
+		model.GetCurrentBuildStatusParams{Blog: blogid, Label: label},
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("current build status: %w", err)
+	}
+	switch {
+	case err == nil && status == model.BuildStatusBuilding:
+	case err == nil && status == model.BuildStatusFailed:
+	default:
+		EnqueueBuild(blogid, label)
+	}
+	return nil
+}
+
+func freshGeneration(blogid, label string, s *model.Store) (int32, error) {
+	if label != "" {
+		return s.GetFreshPreviewGeneration(
+			context.TODO(),
+			model.GetFreshPreviewGenerationParams{
+				Blog: blogid, Label: label,
+			},
+		)
+	}
+	return s.GetFreshGeneration(context.TODO(), blogid)
+}
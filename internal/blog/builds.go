@@ -0,0 +1,192 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/session"
+	"github.com/hylodoc/hylodoc.com/internal/util"
+)
+
+/* number of builds listed on the builds page */
+const listedBuilds = 50
+
+/* number of builds of each blog kept, older ones being deleted */
+const keptBuilds = listedBuilds
+
+/* buildlog collects what happened during a build for its owner to read */
+type buildlog struct {
+	b     strings.Builder
+	start time.Time
+}
+
+func newBuildlog() *buildlog { return &buildlog{start: time.Now()} }
+
+/* Printf writes a line prefixed by the time elapsed since the build started */
+func (l *buildlog) Printf(format string, a ...any) {
+	fmt.Fprintf(
+		&l.b, "[%6.2fs] %s\n",
+		time.Since(l.start).Seconds(), fmt.Sprintf(format, a...),
+	)
+}
+
+func (l *buildlog) String() string { return l.b.String() }
+
+/* recordBuild runs fn, recording it as a build of the blog's live site, or of
+ * its preview if label is set, at the given hash with the blog's theme and
+ * content root. A failed build leaves the blog's latest generation to be
+ * served, and isn't retried by readers until one of these changes. Panics are
+ * recovered so that a bad repository can't take the workers down. */
+func recordBuild(
+	b *model.Blog, label, hash string, s *model.Store,
+	fn func(*buildlog) (int32, error),
+) (gen int32, err error) {
+	/* make room for this one */
+	if err := s.DeleteOldBuilds(
+		context.TODO(),
+		model.DeleteOldBuildsParams{Blog: b.ID, Keep: keptBuilds - 1},
+	); err != nil {
+		return -1, fmt.Errorf("delete old builds: %w", err)
+	}
+	id, err := s.InsertBuild(
+		context.TODO(),
+		model.InsertBuildParams{
+			Blog:        b.ID,
+			Label:       label,
+			Hash:        hash,
+			Theme:       b.Theme,
+			ContentRoot: b.ContentRoot,
+			BootID:      bootid(),
+		},
+	)
+	if err != nil {
+		return -1, fmt.Errorf("insert build: %w", err)
+	}
+	lg := newBuildlog()
+	defer func() {
+		if r := recover(); r != nil {
+			gen, err = -1, fmt.Errorf("panic: %v", r)
+		}
+		if err != nil {
+			lg.Printf("error: %v", err)
+			if ferr := s.FailBuild(
+				context.TODO(),
+				model.FailBuildParams{ID: id, Log: lg.String()},
+			); ferr != nil {
+				err = errors.Join(err, fmt.Errorf("fail build: %w", ferr))
+			}
+			return
+		}
+		lg.Printf("done")
+		if serr := s.SucceedBuild(
+			context.TODO(),
+			model.SucceedBuildParams{
+				ID:  id,
+				Gen: sql.NullInt32{Int32: gen, Valid: true},
+				Log: lg.String(),
+			},
+		); serr != nil {
+			err = fmt.Errorf("succeed build: %w", serr)
+		}
+	}()
+	return fn(lg)
+}
+
+type BuildInfo struct {
+	ID         int32
+	Preview    string /* the preview's label, empty for the live site */
+	Hash       string
+	HashUrl    string
+	Status     string
+	StartedAt  time.Time
+	Duration   string
+	Log        string
+	IsFailed   bool
+	IsBuilding bool
+	IsLive     bool /* of the live site, at the commit being served */
+	CanPin     bool
+}
+
+// Builds lists the blog's recent builds, each with its log.
+func (b *BlogService) Builds(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("Builds handler...")
+
+	r.MixpanelTrack("Builds")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	blog, err := b.store.GetBlogByID(context.TODO(), blogID)
+	if err != nil {
+		return nil, fmt.Errorf("get blog: %w", err)
+	}
+	ghurl, err := getghurl(&blog, b.store)
+	if err != nil {
+		return nil, fmt.Errorf("ghurl: %w", err)
+	}
+	builds, err := b.store.ListBuildsByBlog(
+		context.TODO(),
+		model.ListBuildsByBlogParams{Blog: blogID, Limit: listedBuilds},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list builds: %w", err)
+	}
+	info := make([]BuildInfo, len(builds))
+	for i, build := range builds {
+		islive := build.Label == "" && build.Hash == blog.LiveHash.String
+		info[i] = BuildInfo{
+			ID:         build.ID,
+			Preview:    build.Label,
+			Hash:       build.Hash,
+			HashUrl:    ghurl.JoinPath("commit", build.Hash).String(),
+			Status:     string(build.Status),
+			StartedAt:  build.StartedAt,
+			Log:        build.Log,
+			IsFailed:   build.Status == model.BuildStatusFailed,
+			IsBuilding: build.Status == model.BuildStatusBuilding,
+			IsLive:     islive,
+			CanPin: build.Label == "" && !islive &&
+				build.Status == model.BuildStatusSucceeded,
+		}
+		if build.FinishedAt.Valid {
+			info[i].Duration = build.FinishedAt.Time.Sub(
+				build.StartedAt,
+			).Round(time.Millisecond).String()
+		}
+	}
+
+	return response.NewTemplate(
+		[]string{"builds.html"},
+		util.PageInfo{
+			Data: struct {
+				Title     string
+				UserInfo  *session.UserInfo
+				SiteName  string
+				ConfigUrl string
+				IsPinned  bool
+				Hash      string
+				Builds    []BuildInfo
+			}{
+				Title:     "Builds",
+				UserInfo:  session.ConvertSessionToUserInfo(sesh),
+				SiteName:  getname(&blog),
+				ConfigUrl: buildConfigUrl(blogID),
+				IsPinned:  blog.Pinned,
+				Hash:      blog.LiveHash.String,
+				Builds:    info,
+			},
+		},
+	), nil
+}
@@ -16,3 +16,8 @@ func createCustomError(Message string, Code int) *customError {
 func (e *customError) Error() string {
 	return e.Message
 }
+
+/* implements handler.StatusError */
+func (e *customError) StatusCode() int {
+	return e.Code
+}
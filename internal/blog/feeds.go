@@ -0,0 +1,153 @@
+package blog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/feed"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc/pkg/ssg"
+)
+
+const summaryLength = 300
+
+/* generateFeeds writes the site's RSS, Atom and JSON feeds into dst and returns
+ * the bindings for them. link is the absolute URL the site is served at. */
+func generateFeeds(
+	site ssg.Site, title, link, dst string, settings feedSettings,
+) (map[string]string, error) {
+	f, err := buildFeed(site, title, link, settings)
+	if err != nil {
+		return nil, fmt.Errorf("build: %w", err)
+	}
+	files := map[string][]byte{}
+	for url, render := range map[string]func() ([]byte, error){
+		feed.RSSPath:  f.RSS,
+		feed.AtomPath: f.Atom,
+		feed.JSONPath: f.JSON,
+	} {
+		b, err := render()
+		if err != nil {
+			return nil, fmt.Errorf("render %s: %w", url, err)
+		}
+		files[url] = b
+	}
+	return writeFiles(dst, files)
+}
+
+/* feedSettings are the blog's, or the defaults in conf.yml for those it hasn't
+ * set */
+type feedSettings struct {
+	/* most recent posts in each feed, all of them if not positive */
+	entries int
+	/* whether entries carry the post's HTML or only a plaintext summary */
+	fullContent bool
+}
+
+func getFeedSettings(b *model.Blog) feedSettings {
+	settings := feedSettings{
+		entries:     config.Config.Feeds.Entries,
+		fullContent: config.Config.Feeds.FullContent,
+	}
+	if b.FeedEntries.Valid {
+		settings.entries = int(b.FeedEntries.Int32)
+	}
+	if b.FeedFullContent.Valid {
+		settings.fullContent = b.FeedFullContent.Bool
+	}
+	return settings
+}
+
+func buildFeed(
+	site ssg.Site, title, link string, settings feedSettings,
+) (*feed.Feed, error) {
+	var entries []feed.Entry
+	for url, rsc := range site.Bindings() {
+		if !rsc.IsPost() || url == NotFoundURL {
+			continue
+		}
+		entry, err := buildEntry(
+			rsc.Post(), link+url, settings.fullContent,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		entries = append(entries, *entry)
+	}
+	/* most recent first, undated posts last */
+	sort.SliceStable(entries, func(i, j int) bool {
+		pi, pj := entries[i].Published, entries[j].Published
+		if pi.Equal(pj) {
+			return entries[i].Title < entries[j].Title
+		}
+		return pi.After(pj)
+	})
+	if n := settings.entries; n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	updated := time.Now()
+	if len(entries) > 0 && !entries[0].Published.IsZero() {
+		updated = entries[0].Published
+	}
+	return &feed.Feed{
+		Title:   title,
+		Link:    link,
+		Updated: updated,
+		Entries: entries,
+	}, nil
+}
+
+func buildEntry(
+	post ssg.Post, link string, fullContent bool,
+) (*feed.Entry, error) {
+	text, err := os.ReadFile(post.PlaintextPath())
+	if err != nil {
+		return nil, fmt.Errorf("read plaintext: %w", err)
+	}
+	entry := feed.Entry{
+		Title:   post.Title(),
+		Link:    link,
+		Summary: summarise(string(text)),
+	}
+	if t, ok := post.Time(); ok {
+		entry.Published = t
+	}
+	if fullContent {
+		html, err := os.ReadFile(post.HtmlPath())
+		if err != nil {
+			return nil, fmt.Errorf("read html: %w", err)
+		}
+		entry.Content = string(html)
+	}
+	return &entry, nil
+}
+
+/* summarise returns the first paragraph of text, shortened if need be */
+func summarise(text string) string {
+	para, _, _ := strings.Cut(strings.TrimSpace(text), "\n\n")
+	para = strings.Join(strings.Fields(para), " ")
+	if utf8.RuneCountInString(para) <= summaryLength {
+		return para
+	}
+	runes := []rune(para)[:summaryLength]
+	if i := strings.LastIndex(string(runes), " "); i > 0 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
+
+/* siteURL is the absolute URL the blog is served at, preferring its custom
+ * domain */
+func siteURL(b *model.Blog) string {
+	if b.Domain.Valid {
+		return fmt.Sprintf(
+			"%s://%s", config.Config.Hylodoc.Protocol, b.Domain.String,
+		)
+	}
+	return buildUrl(b.Subdomain.String())
+}
@@ -5,19 +5,64 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/hylodoc/hylodoc.com/internal/assert"
 	"github.com/hylodoc/hylodoc.com/internal/authz"
 	"github.com/hylodoc/hylodoc.com/internal/config"
 	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/precompress"
+	"github.com/hylodoc/hylodoc.com/internal/redirects"
 	"github.com/hylodoc/hylodoc/pkg/ssg"
 )
 
-func GetFreshGeneration(blogid string, s *model.Store) (int32, error) {
-	freshgen, err := s.GetFreshGeneration(context.TODO(), blogid)
+/* generate builds the blog's fresh generation unless it already has one. The
+ * head of a pinned blog is built too, so that unpinning it is instant. */
+func generate(blogid string, s *model.Store) (int32, error) {
+	b, err := s.GetBlogByID(context.TODO(), blogid)
+	if err != nil {
+		return -1, fmt.Errorf("cannot get blog: %w", err)
+	}
+	if !b.LiveHash.Valid {
+		return -1, fmt.Errorf("no live hash")
+	}
+	gen, err := generateCommit(&b, b.LiveHash.String, s)
+	if err != nil {
+		return -1, err
+	}
+	if b.Pinned && b.HeadHash.Valid && b.HeadHash != b.LiveHash {
+		if _, err := generateCommit(&b, b.HeadHash.String, s); err != nil {
+			return -1, fmt.Errorf("head: %w", err)
+		}
+	}
+	return gen, nil
+}
+
+/* generateCommit builds the blog at the given commit unless it has a fresh
+ * generation of it. The blog's name and posts are only taken from the commit
+ * if it is live, which a fresh generation of it built as the head of a pinned
+ * blog may since have become. */
+func generateCommit(b *model.Blog, hash string, s *model.Store) (int32, error) {
+	live := hash == b.LiveHash.String
+	freshgen, err := s.GetFreshGenerationByHash(
+		context.TODO(),
+		model.GetFreshGenerationByHashParams{
+			Blog: sql.NullString{String: b.ID, Valid: true},
+			Hash: hash,
+		},
+	)
 	if err == nil {
+		if live {
+			if err := s.ExecTx(func(tx *model.Store) error {
+				return publishGeneration(freshgen, b.ID, tx)
+			}); err != nil {
+				return -1, fmt.Errorf("publish: %w", err)
+			}
+		}
 		return freshgen, nil
 	}
 	if !errors.Is(err, sql.ErrNoRows) {
@@ -25,72 +70,316 @@ func GetFreshGeneration(blogid string, s *model.Store) (int32, error) {
 	}
 	assert.Assert(errors.Is(err, sql.ErrNoRows))
 
-	b, err := s.GetBlogByID(context.TODO(), blogid)
+	commit := *b
+	commit.LiveHash = sql.NullString{String: hash, Valid: true}
+	return recordBuild(
+		b, "", hash, s,
+		func(lg *buildlog) (int32, error) {
+			return generateSite(&commit, siteopts{publish: live}, lg, s)
+		},
+	)
+}
+
+/* what a site is generated for */
+type siteopts struct {
+	/* the label of the preview, empty for the live site */
+	label string
+	/* whether the blog's name and posts are taken from the live site's
+	 * generation, rather than it only being recorded */
+	publish bool
+}
+
+/* generateSite generates the blog, or its preview, at its live hash, which
+ * needn't be the one in the db. The live site gets a sitemap, whereas a
+ * preview gets a robots.txt that keeps it out of search engines and nothing
+ * recorded about its posts, so that they are never emailed or counted. */
+func generateSite(
+	b *model.Blog, opts siteopts, lg *buildlog, s *model.Store,
+) (int32, error) {
+	src, err := contentPath(b, lg)
 	if err != nil {
-		return -1, fmt.Errorf("cannot get blog: %w", err)
+		return -1, err
 	}
-	site, err := ssgGenerateWithAuthZRestrictions(&b, s)
+	dst := newWebsitePath(b)
+	lg.Printf("generating %s with theme %s", b.LiveHash.String, b.Theme)
+	site, err := ssgGenerateWithAuthZRestrictions(b, src, dst, s)
 	if err != nil {
 		return -1, fmt.Errorf("generate with authz: %w", err)
 	}
-	if title := site.Title(); title != "" {
+	logSite(site, b, lg)
+	if err := precompressSite(site); err != nil {
+		return -1, fmt.Errorf("precompress: %w", err)
+	}
+	url := siteURL(b)
+	if opts.label != "" {
+		url = PreviewURL(opts.label, b.Subdomain)
+	}
+	feeds, err := generateFeeds(
+		site, sitetitle(site, b), url, dst, getFeedSettings(b),
+	)
+	if err != nil {
+		return -1, fmt.Errorf("feeds: %w", err)
+	}
+	var extras map[string]string
+	if opts.label != "" {
+		if extras, err = generatePreviewRobots(dst); err != nil {
+			return -1, fmt.Errorf("robots: %w", err)
+		}
+	} else {
+		extras, err = generateSitemap(site, b, src, url, dst)
+		if err != nil {
+			return -1, fmt.Errorf("sitemap: %w", err)
+		}
+	}
+	notfound, err := generateNotFoundPage(src, dst)
+	if err != nil {
+		return -1, fmt.Errorf("404 page: %w", err)
+	}
+	rules, err := readRedirects(src, lg)
+	if err != nil {
+		return -1, fmt.Errorf("redirects: %w", err)
+	}
+	lg.Printf("%d redirect rules", len(rules))
+	/* readers are switched over to the generation once it's complete */
+	var gen int32
+	if err := s.ExecTx(func(tx *model.Store) error {
+		gen, err = insertGeneration(b, site.Title(), dst, opts.label, tx)
+		if err != nil {
+			return fmt.Errorf("error inserting generation: %w", err)
+		}
+		if err := insertRedirects(gen, rules, tx); err != nil {
+			return err
+		}
+		if err := insertSiteBindings(
+			gen, site, opts.label == "", tx,
+		); err != nil {
+			return err
+		}
+		if err := insertBindings(gen, feeds, tx); err != nil {
+			return err
+		}
+		if err := insertBindings(gen, notfound, tx); err != nil {
+			return err
+		}
+		if err := insertBindings(gen, extras, tx); err != nil {
+			return err
+		}
+		if !opts.publish {
+			return nil
+		}
+		return publishGeneration(gen, b.ID, tx)
+	}); err != nil {
+		return -1, err
+	}
+	return gen, nil
+}
+
+/* insertGeneration records a generation of the live site, or of the preview
+ * with the given label */
+func insertGeneration(
+	b *model.Blog, title, dir, label string, s *model.Store,
+) (int32, error) {
+	if label != "" {
+		return s.InsertPreviewGeneration(
+			context.TODO(),
+			model.InsertPreviewGenerationParams{
+				Hash:  b.LiveHash.String,
+				Label: label,
+				Dir:   dir,
+				Blog:  b.ID,
+			},
+		)
+	}
+	return s.InsertGeneration(
+		context.TODO(),
+		model.InsertGenerationParams{
+			Hash:  b.LiveHash.String,
+			Dir:   dir,
+			Title: title,
+			Blog:  b.ID,
+		},
+	)
+}
+
+/* insertSiteBindings binds the site's pages, recording its posts if posts is
+ * set */
+func insertSiteBindings(
+	gen int32, site ssg.Site, posts bool, s *model.Store,
+) error {
+	for url, rsc := range site.Bindings() {
+		if err := s.InsertBinding(
+			context.TODO(),
+			model.InsertBindingParams{
+				Gen:  gen,
+				Url:  url,
+				Path: rsc.Path(),
+			},
+		); err != nil {
+			return fmt.Errorf("error inserting binding: %w", err)
+		}
+		/* a 404.md is parsed as a post but mustn't be emailed */
+		if !posts || !rsc.IsPost() || url == NotFoundURL {
+			continue
+		}
+		post := rsc.Post()
+		if err := s.InsertGenerationPost(
+			context.TODO(),
+			model.InsertGenerationPostParams{
+				Gen:         gen,
+				Url:         url,
+				PublishedAt: publishedat(post),
+				Title:       post.Title(),
+			},
+		); err != nil {
+			return fmt.Errorf("error inserting post: %w", err)
+		}
+		if err := s.InsertPostEmailBinding(
+			context.TODO(),
+			model.InsertPostEmailBindingParams{
+				Gen:  gen,
+				Url:  url,
+				Html: post.HtmlPath(),
+				Text: post.PlaintextPath(),
+			},
+		); err != nil {
+			return fmt.Errorf("cannot insert email params: %w", err)
+		}
+	}
+	return nil
+}
+
+/* publishGeneration takes the blog's name and posts from its generation, whose
+ * commit is live */
+func publishGeneration(gen int32, blogid string, s *model.Store) error {
+	title, err := s.GetGenerationTitle(context.TODO(), gen)
+	if err != nil {
+		return fmt.Errorf("get title: %w", err)
+	}
+	if title != "" {
 		if err := s.UpdateBlogName(
 			context.TODO(),
-			model.UpdateBlogNameParams{
-				ID:   b.ID,
-				Name: title,
+			model.UpdateBlogNameParams{ID: blogid, Name: title},
+		); err != nil {
+			return fmt.Errorf("cannot set title %q: %w", title, err)
+		}
+	}
+	posts, err := s.ListGenerationPosts(context.TODO(), gen)
+	if err != nil {
+		return fmt.Errorf("list posts: %w", err)
+	}
+	for _, p := range posts {
+		if err := upsertPost(
+			model.InsertRPostParams{
+				Url:         p.Url,
+				Blog:        blogid,
+				PublishedAt: p.PublishedAt,
+				Title:       p.Title,
 			},
+			s,
 		); err != nil {
-			return -1, fmt.Errorf("cannot set title %q: %w", title, err)
+			return fmt.Errorf("error upserting post: %w", err)
 		}
 	}
-	if !b.LiveHash.Valid {
-		return -1, fmt.Errorf("no live hash: %w", err)
+	return nil
+}
+
+/* generatePreview is generate for the preview of the blog with the given
+ * label. Nothing is recorded about the posts of a preview, so they are never
+ * emailed or counted. */
+func generatePreview(
+	blogid, label string, s *model.Store,
+) (int32, error) {
+	freshgen, err := s.GetFreshPreviewGeneration(
+		context.TODO(),
+		model.GetFreshPreviewGenerationParams{Blog: blogid, Label: label},
+	)
+	if err == nil {
+		return freshgen, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return -1, fmt.Errorf("query error: %w", err)
 	}
-	gen, err := s.InsertGeneration(context.TODO(), b.LiveHash.String)
+	assert.Assert(errors.Is(err, sql.ErrNoRows))
+
+	b, err := s.GetBlogByID(context.TODO(), blogid)
 	if err != nil {
-		return -1, fmt.Errorf("error inserting generation: %w", err)
+		return -1, fmt.Errorf("cannot get blog: %w", err)
 	}
-	for url, rsc := range site.Bindings() {
+	p, err := s.GetPreview(
+		context.TODO(),
+		model.GetPreviewParams{Blog: blogid, Label: label},
+	)
+	if err != nil {
+		return -1, fmt.Errorf("cannot get preview: %w", err)
+	}
+	return recordBuild(
+		&b, label, p.Hash, s,
+		func(lg *buildlog) (int32, error) {
+			/* generate the preview's commit as though it were live */
+			b.LiveHash = sql.NullString{String: p.Hash, Valid: true}
+			return generateSite(&b, siteopts{label: label}, lg, s)
+		},
+	)
+}
+
+/* logSite records what the SSG made of the repository, warning about what the
+ * owner may not have intended */
+func logSite(site ssg.Site, b *model.Blog, lg *buildlog) {
+	pages, posts := 0, 0
+	for _, rsc := range site.Bindings() {
+		pages++
+		if rsc.IsPost() {
+			posts++
+		}
+	}
+	lg.Printf("%d pages, of which %d posts", pages, posts)
+	if posts == 0 {
+		lg.Printf("warning: no posts")
+	}
+	if site.Title() == "" {
+		lg.Printf("warning: no site title, using %q", getname(b))
+	}
+}
+
+/* insertBindings binds files we generate alongside the site */
+func insertBindings(
+	gen int32, bindings map[string]string, s *model.Store,
+) error {
+	for url, path := range bindings {
 		if err := s.InsertBinding(
 			context.TODO(),
 			model.InsertBindingParams{
 				Gen:  gen,
 				Url:  url,
-				Path: rsc.Path(),
+				Path: path,
 			},
 		); err != nil {
-			return -1, fmt.Errorf("error inserting binding: %w", err)
+			return fmt.Errorf("error inserting binding: %w", err)
 		}
-		if rsc.IsPost() {
-			post := rsc.Post()
-			if err := upsertPost(
-				post, url, b.ID, s,
-			); err != nil {
-				return -1, fmt.Errorf(
-					"error upserting post: %w", err,
-				)
-			}
-			if err := s.InsertPostEmailBinding(
-				context.TODO(),
-				model.InsertPostEmailBindingParams{
-					Gen:  gen,
-					Url:  url,
-					Html: post.HtmlPath(),
-					Text: post.PlaintextPath(),
-				},
-			); err != nil {
-				return -1, fmt.Errorf(
-					"cannot insert email params: %w", err,
-				)
-			}
+	}
+	return nil
+}
+
+func insertRedirects(gen int32, rules []redirects.Rule, s *model.Store) error {
+	for i, rule := range rules {
+		if err := s.InsertRedirect(
+			context.TODO(),
+			model.InsertRedirectParams{
+				Gen:         gen,
+				Priority:    int32(i),
+				Source:      rule.Source,
+				Destination: rule.Destination,
+				Status:      int32(rule.Status),
+			},
+		); err != nil {
+			return fmt.Errorf("error inserting redirect: %w", err)
 		}
 	}
-	return gen, nil
+	return nil
 }
 
 func ssgGenerateWithAuthZRestrictions(
-	b *model.Blog, s *model.Store,
+	b *model.Blog, src, dst string, s *model.Store,
 ) (ssg.Site, error) {
 	canHaveSubs, err := authz.HasAnalyticsCustomDomainsImagesEmails(
 		s, b.UserID,
@@ -98,16 +387,6 @@ func ssgGenerateWithAuthZRestrictions(
 	if err != nil {
 		return nil, fmt.Errorf("can have subscribers: %w", err)
 	}
-	assert.Assert(b.LiveHash.Valid)
-	src := filepath.Join(
-		config.Config.Hylodoc.CheckoutsPath,
-		b.LiveHash.String,
-	)
-	dst := filepath.Join(
-		config.Config.Hylodoc.WebsitesPath,
-		b.Subdomain.String(),
-		uuid.New().String(),
-	)
 	link := fmt.Sprintf(
 		"%s://%s",
 		config.Config.Hylodoc.Protocol,
@@ -124,7 +403,7 @@ func ssgGenerateWithAuthZRestrictions(
 				"<div style=\"text-align: center; padding: 20px;\">Powered by <a href=\"%s\" target=\"_blank\">Hylodoc</a></div>",
 				link,
 			),
-			map[string]ssg.CustomPage{
+			withNotFoundPage(src, map[string]ssg.CustomPage{
 				"/unsubscribed": ssg.NewMessagePage(
 					"Unsubscribed",
 					`<p>
@@ -132,7 +411,7 @@ func ssgGenerateWithAuthZRestrictions(
 					You will no longer receive email updates for posts.
 				</p>`,
 				),
-			},
+			}),
 		)
 	}
 	return ssg.GenerateSiteWithBindings(
@@ -145,7 +424,7 @@ func ssgGenerateWithAuthZRestrictions(
 			"<p>Subscribe via <a href=\"/subscribe\">email</a>.</p><div style=\"text-align: center; padding: 20px;\">Powered by <a href=\"%s\" target=\"_blank\">Hylodoc</a></div>",
 			link,
 		),
-		map[string]ssg.CustomPage{
+		withNotFoundPage(src, map[string]ssg.CustomPage{
 			"/subscribe": ssg.NewSubscriberPage(
 				fmt.Sprintf(
 					"%s://%s/blogs/%s/subscribe",
@@ -169,24 +448,154 @@ func ssgGenerateWithAuthZRestrictions(
 					<a href="/subscribe">here</a>.
 				</p>`,
 			),
-		},
+		}),
 	)
 }
 
-func upsertPost(
-	post ssg.Post, url string, blogid string, s *model.Store,
-) error {
-	params := model.InsertRPostParams{
-		Url:         url,
-		Blog:        blogid,
-		PublishedAt: publishedat(post),
-		Title:       post.Title(),
+const NotFoundURL = "/404"
+
+/* withNotFoundPage adds a page with a default message at NotFoundURL, which
+ * is served for unknown paths, unless the repository has a 404.md (which the
+ * SSG binds there itself) or a 404.html (see generateNotFoundPage). */
+func withNotFoundPage(
+	src string, pages map[string]ssg.CustomPage,
+) map[string]ssg.CustomPage {
+	for _, name := range []string{"404.md", "404.html"} {
+		if _, err := os.Stat(filepath.Join(src, name)); err == nil {
+			return pages
+		}
+	}
+	pages[NotFoundURL] = ssg.NewMessagePage(
+		"Page not found",
+		`<p>There's nothing here.</p>
+		<p>Return to the <a href="/">home page</a>.</p>`,
+	)
+	return pages
+}
+
+/* generateNotFoundPage copies a 404.html in the repository into dst and binds
+ * it at NotFoundURL as a standalone page, without the theme, unless there is
+ * a 404.md, which takes precedence. */
+func generateNotFoundPage(src, dst string) (map[string]string, error) {
+	if _, err := os.Stat(filepath.Join(src, "404.md")); err == nil {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filepath.Join(src, "404.html"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read 404.html: %w", err)
+	}
+	files, err := writeFiles(dst, map[string][]byte{"/404.html": b})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{NotFoundURL: files["/404.html"]}, nil
+}
+
+/* every generation is written to a new directory */
+func newWebsitePath(b *model.Blog) string {
+	return filepath.Join(
+		config.Config.Hylodoc.WebsitesPath,
+		b.Subdomain.String(),
+		uuid.New().String(),
+	)
+}
+
+func sitetitle(site ssg.Site, b *model.Blog) string {
+	if title := site.Title(); title != "" {
+		return title
+	}
+	return getname(b)
+}
+
+func checkoutPath(b *model.Blog) string {
+	assert.Assert(b.LiveHash.Valid)
+	return filepath.Join(
+		config.Config.Hylodoc.CheckoutsPath,
+		b.LiveHash.String,
+	)
+}
+
+/* contentPath returns the directory of the checkout that the blog is generated
+ * from. Its content root is validated when set, but may be a symlink in the
+ * repository, so where it resolves to is checked too. */
+func contentPath(b *model.Blog, lg *buildlog) (string, error) {
+	checkout := checkoutPath(b)
+	if b.ContentRoot == "" {
+		return checkout, nil
 	}
+	lg.Printf("content root %s", b.ContentRoot)
+	src, err := filepath.EvalSymlinks(
+		filepath.Join(checkout, filepath.FromSlash(b.ContentRoot)),
+	)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf(
+				"content root %s not in repository", b.ContentRoot,
+			)
+		}
+		return "", fmt.Errorf("content root: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(checkout)
+	if err != nil {
+		return "", fmt.Errorf("checkout: %w", err)
+	}
+	rel, err := filepath.Rel(root, src)
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf(
+			"content root %s leads out of repository", b.ContentRoot,
+		)
+	}
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return "", fmt.Errorf(
+			"content root %s not a directory", b.ContentRoot,
+		)
+	}
+	return src, nil
+}
+
+/* readRedirects parses the _redirects file at the root of the content, which
+ * the SSG doesn't bind because it isn't a page. Invalid lines are skipped and
+ * logged for the owner to fix. */
+func readRedirects(src string, lg *buildlog) ([]redirects.Rule, error) {
+	f, err := os.Open(filepath.Join(src, redirects.Filename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	rules, invalid, err := redirects.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range invalid {
+		lg.Printf("warning: %s: skipping %v", redirects.Filename, err)
+	}
+	return rules, nil
+}
+
+/* precompressSite writes compressed variants of the site's text files next to
+ * them so that they can be served without compressing on every request */
+func precompressSite(site ssg.Site) error {
+	for url, rsc := range site.Bindings() {
+		if err := precompress.CompressFile(rsc.Path()); err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+	}
+	return nil
+}
+
+func upsertPost(params model.InsertRPostParams, s *model.Store) error {
 	if _, err := s.GetPostExists(
 		context.TODO(),
 		model.GetPostExistsParams{
-			Url:  url,
-			Blog: blogid,
+			Url:  params.Url,
+			Blog: params.Blog,
 		},
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -0,0 +1,42 @@
+package contentroot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/* the length of blogs.content_root */
+const maxlen = 1000
+
+// Parse returns the directory of a repository that a blog is generated from,
+// given relative to the repository's root with forward slashes. Leading and
+// trailing slashes are dropped, so the root itself is "". Anything that could
+// lead out of the repository or into its .git is rejected.
+func Parse(raw string) (string, error) {
+	root := strings.Trim(strings.TrimSpace(raw), "/")
+	if len(root) > maxlen {
+		return "", fmt.Errorf(
+			"must be at most %d characters long", maxlen,
+		)
+	}
+	for _, r := range root {
+		if r == '\\' || unicode.IsControl(r) {
+			return "", fmt.Errorf("cannot contain %q", r)
+		}
+	}
+	if root == "" {
+		return "", nil
+	}
+	for _, dir := range strings.Split(root, "/") {
+		switch dir {
+		case "":
+			return "", fmt.Errorf("cannot contain consecutive slashes")
+		case ".", "..":
+			return "", fmt.Errorf("cannot contain %q", dir)
+		case ".git":
+			return "", fmt.Errorf("cannot be in .git")
+		}
+	}
+	return root, nil
+}
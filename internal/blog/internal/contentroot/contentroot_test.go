@@ -0,0 +1,41 @@
+package contentroot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw  string
+		root string
+		ok   bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{" docs ", "docs", true},
+		{"/docs/", "docs", true},
+		{"site/blog", "site/blog", true},
+		{"my blog", "my blog", true},
+		{".github", ".github", true},
+		{"..", "", false},
+		{"../other", "", false},
+		{"docs/../../etc", "", false},
+		{"docs/./blog", "", false},
+		{"docs//blog", "", false},
+		{`docs\..\..`, "", false},
+		{".git", "", false},
+		{"docs/.git/hooks", "", false},
+		{"docs\x00", "", false},
+		{strings.Repeat("a", 1001), "", false},
+	}
+	for _, tt := range tests {
+		root, err := Parse(tt.raw)
+		if ok := err == nil; ok != tt.ok || root != tt.root {
+			t.Errorf(
+				"Parse(%q) = %q, %v, want %q, ok %v",
+				tt.raw, root, err, tt.root, tt.ok,
+			)
+		}
+	}
+}
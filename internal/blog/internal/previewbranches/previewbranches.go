@@ -0,0 +1,41 @@
+package previewbranches
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+/* the length of blogs.preview_branches */
+const maxlen = 1000
+
+// Parse returns the patterns of the branches a blog previews, separated by
+// single spaces, given separated by any whitespace. Each is a path.Match
+// pattern, so `*' matches within a segment of the branch name (feature/*
+// matches feature/a but not feature/a/b). No patterns, "", disables previews.
+func Parse(raw string) (string, error) {
+	patterns := strings.Fields(raw)
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return "", fmt.Errorf("%q is not a valid pattern", p)
+		}
+	}
+	joined := strings.Join(patterns, " ")
+	if len(joined) > maxlen {
+		return "", fmt.Errorf(
+			"must be at most %d characters long", maxlen,
+		)
+	}
+	return joined, nil
+}
+
+// Match reports whether the branch is previewed under the given patterns, as
+// returned by Parse.
+func Match(patterns, branch string) bool {
+	for _, p := range strings.Fields(patterns) {
+		if ok, _ := path.Match(p, branch); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package previewbranches
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw      string
+		patterns string
+		ok       bool
+	}{
+		{"", "", true},
+		{"  ", "", true},
+		{"*", "*", true},
+		{" feature/*\n  fix-* ", "feature/* fix-*", true},
+		{"feature/[", "", false},
+		{strings.Repeat("a", 1001), "", false},
+	}
+	for _, tt := range tests {
+		patterns, err := Parse(tt.raw)
+		if ok := err == nil; ok != tt.ok || patterns != tt.patterns {
+			t.Errorf(
+				"Parse(%q) = %q, %v, want %q, ok %v",
+				tt.raw, patterns, err, tt.patterns, tt.ok,
+			)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns string
+		branch   string
+		match    bool
+	}{
+		{"", "feature/a", false},
+		{"*", "draft", true},
+		{"*", "feature/a", false},
+		{"feature/*", "feature/a", true},
+		{"feature/*", "feature/a/b", false},
+		{"draft feature/*", "draft", true},
+		{"fix-*", "feature-a", false},
+	}
+	for _, tt := range tests {
+		if match := Match(tt.patterns, tt.branch); match != tt.match {
+			t.Errorf(
+				"Match(%q, %q) = %v, want %v",
+				tt.patterns, tt.branch, match, tt.match,
+			)
+		}
+	}
+}
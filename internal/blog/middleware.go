@@ -52,8 +52,8 @@ func (b *BlogService) middleware(w http.ResponseWriter, r *http.Request) error {
 		return createCustomError("", http.StatusNotFound)
 	}
 
-	if _, err := GetFreshGeneration(blogID, b.store); err != nil {
-		return fmt.Errorf("get fresh generation: %w", err)
+	if err := enqueueIfStale(blogID, b.store); err != nil {
+		return fmt.Errorf("enqueue if stale: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,222 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/request"
+	"github.com/hylodoc/hylodoc.com/internal/app/handler/response"
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+// PinSubmit pins the blog to the commit of one of its successful builds, or to
+// any commit of its live branch given by (an abbreviation of) its hash, which
+// is built if it hasn't been. While a blog is pinned, pushes to its live branch
+// are built but not served.
+func (b *BlogService) PinSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("PinSubmit handler...")
+
+	r.MixpanelTrack("PinSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	rawcommit, err := r.GetPostFormValue("commit")
+	if err != nil {
+		sesh.Printf("cannot get post form value: %v\n", err)
+		return nil, createCustomError(
+			"Error parsing form", http.StatusBadRequest,
+		)
+	}
+	if rawcommit != "" {
+		rev := strings.ToLower(strings.TrimSpace(rawcommit))
+		if !isAbbrevHash(rev) {
+			return nil, createCustomError(
+				"invalid commit hash", http.StatusBadRequest,
+			)
+		}
+		hash, err := b.pin(blogID, rev, true)
+		if err != nil {
+			if errors.Is(err, errNotOnBranch) {
+				return nil, createCustomError(
+					"no such commit on the live branch",
+					http.StatusBadRequest,
+				)
+			}
+			return nil, fmt.Errorf("pin: %w", err)
+		}
+		sesh.Printf("pinned blog %s to %s\n", blogID, hash)
+		return redirectToBuilds(blogID), nil
+	}
+	rawbuild, err := r.GetPostFormValue("build")
+	if err != nil {
+		sesh.Printf("cannot get post form value: %v\n", err)
+		return nil, createCustomError(
+			"Error parsing form", http.StatusBadRequest,
+		)
+	}
+	buildID, err := strconv.ParseInt(rawbuild, 10, 32)
+	if err != nil {
+		return nil, createCustomError(
+			"invalid build", http.StatusBadRequest,
+		)
+	}
+	build, err := b.store.GetBuild(
+		context.TODO(),
+		model.GetBuildParams{ID: int32(buildID), Blog: blogID},
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, createCustomError("", http.StatusNotFound)
+		}
+		return nil, fmt.Errorf("get build: %w", err)
+	}
+	if build.Label != "" || build.Status != model.BuildStatusSucceeded {
+		return nil, createCustomError(
+			"only successful builds of the live site can be pinned",
+			http.StatusBadRequest,
+		)
+	}
+	if _, err := b.pin(blogID, build.Hash, false); err != nil {
+		return nil, fmt.Errorf("pin: %w", err)
+	}
+	sesh.Printf("pinned blog %s to %s\n", blogID, build.Hash)
+	return redirectToBuilds(blogID), nil
+}
+
+/* minimum length of an abbreviated commit hash, as git's default */
+const minAbbrevHash = 7
+
+func isAbbrevHash(s string) bool {
+	if len(s) < minAbbrevHash || len(s) > 40 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+var errNotOnBranch = errors.New("not on branch")
+
+/* pin pins the blog to the commit and returns its hash. If resolve is set, rev
+ * may be abbreviated and must be on the live branch as fetched. The commit is
+ * checked out again in case its checkout has been removed. */
+func (b *BlogService) pin(blogID, rev string, resolve bool) (string, error) {
+	blog, err := b.store.GetBlogByID(context.TODO(), blogID)
+	if err != nil {
+		return "", fmt.Errorf("get blog: %w", err)
+	}
+	repo, err := b.store.GetRepositoryByGhRepositoryID(
+		context.TODO(), blog.GhRepositoryID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("get repo: %w", err)
+	}
+	hash := rev
+	if err := b.store.WithGitdirLock(repo.GitdirPath, func() error {
+		if resolve {
+			var err error
+			hash, err = findBranchCommit(
+				repo.GitdirPath, blog.LiveBranch, rev,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return checkoutCommit(repo.GitdirPath, blog.LiveBranch, hash)
+	}); err != nil {
+		return "", fmt.Errorf("checkout: %w", err)
+	}
+	if err := b.store.PinBlog(
+		context.TODO(),
+		model.PinBlogParams{ID: blogID, LiveHash: hash},
+	); err != nil {
+		return "", fmt.Errorf("pin blog: %w", err)
+	}
+	EnqueueBuild(blogID, "")
+	return hash, nil
+}
+
+/* findBranchCommit returns the hash of the newest commit of the branch in the
+ * bare git dir whose hash begins with prefix. Only the commits fetched are
+ * searched, so with a fetch depth older ones aren't found. */
+func findBranchCommit(gitdir, branch, prefix string) (string, error) {
+	repo, err := git.PlainOpen(gitdir)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", fmt.Errorf("reference: %w", err)
+	}
+	iter, err := repo.Log(&git.LogOptions{From: ref.Hash()})
+	if err != nil {
+		return "", fmt.Errorf("log: %w", err)
+	}
+	defer iter.Close()
+	found := ""
+	err = iter.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), prefix) {
+			found = c.Hash.String()
+			return storer.ErrStop
+		}
+		return nil
+	})
+	/* the history ends where the fetch's depth does */
+	if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return "", fmt.Errorf("walk: %w", err)
+	}
+	if found == "" {
+		return "", errNotOnBranch
+	}
+	return found, nil
+}
+
+// UnpinSubmit has the blog follow its live branch again.
+func (b *BlogService) UnpinSubmit(
+	r request.Request,
+) (response.Response, error) {
+	sesh := r.Session()
+	sesh.Println("UnpinSubmit handler...")
+
+	r.MixpanelTrack("UnpinSubmit")
+
+	blogID, ok := r.GetRouteVar("blogID")
+	if !ok {
+		return nil, createCustomError("", http.StatusNotFound)
+	}
+	if err := b.store.UnpinBlog(context.TODO(), blogID); err != nil {
+		return nil, fmt.Errorf("unpin blog: %w", err)
+	}
+	EnqueueBuild(blogID, "")
+	return redirectToBuilds(blogID), nil
+}
+
+func redirectToBuilds(blogID string) response.Response {
+	return response.NewRedirect(
+		fmt.Sprintf(
+			"%s://%s%s",
+			config.Config.Hylodoc.Protocol,
+			config.Config.Hylodoc.RootDomain,
+			buildBuildsUrl(blogID),
+		),
+		http.StatusSeeOther,
+	)
+}
@@ -0,0 +1,200 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/authn"
+	"github.com/hylodoc/hylodoc.com/internal/blog/internal/previewbranches"
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/dns"
+	"github.com/hylodoc/hylodoc.com/internal/httpclient"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/session"
+)
+
+// UpdatePreviewOnDisk checks out the latest commit of a branch other than the
+// blog's live branch and points the branch's preview at it, unless it leaves
+// the blog's content root as the preview has it. The preview is generated in
+// the background, like the live site. Only branches matching the blog's
+// preview patterns are previewed.
+func UpdatePreviewOnDisk(
+	c *httpclient.Client, blog *model.Blog, branch string,
+	sesh *session.Session, s *model.Store,
+) error {
+	if !previewbranches.Match(blog.PreviewBranches, branch) {
+		sesh.Printf(
+			"`%s' not previewed (patterns `%s')\n",
+			branch, blog.PreviewBranches,
+		)
+		return nil
+	}
+	label, err := dns.PreviewLabel(branch, blog.Subdomain)
+	if err != nil {
+		return fmt.Errorf("label: %w", err)
+	}
+	repo, err := s.GetRepositoryByGhRepositoryID(
+		context.TODO(), blog.GhRepositoryID,
+	)
+	if err != nil {
+		return fmt.Errorf("get repo: %w", err)
+	}
+	accessToken, err := authn.GetInstallationAccessToken(
+		c,
+		config.Config.Github.AppID,
+		repo.InstallationID,
+		config.Config.Github.PrivateKeyPath,
+	)
+	if err != nil {
+		return fmt.Errorf("access token: %w", err)
+	}
+	prev := ""
+	if p, err := s.GetPreview(
+		context.TODO(),
+		model.GetPreviewParams{Blog: blog.ID, Label: label},
+	); err == nil {
+		if p.Branch != branch {
+			/* labels are disambiguated, so this takes a branch
+			 * named after another's hashed label */
+			sesh.Printf(
+				"`%s' not previewed: label %s taken by `%s'\n",
+				branch, label, p.Branch,
+			)
+			return nil
+		}
+		prev = p.Hash
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("get preview: %w", err)
+	}
+	h, changed, err := updateAndCheckout(
+		repo.Url, repo.GitdirPath, branch, accessToken,
+		blog.ContentRoot, prev, s,
+	)
+	if err != nil {
+		return fmt.Errorf("update and checkout: %w", err)
+	}
+	if !changed {
+		sesh.Printf(
+			"%s leaves content root `%s' unchanged\n",
+			h, blog.ContentRoot,
+		)
+		return nil
+	}
+	if err := s.UpsertPreview(
+		context.TODO(),
+		model.UpsertPreviewParams{
+			Blog:   blog.ID,
+			Label:  label,
+			Branch: branch,
+			Hash:   h,
+		},
+	); err != nil {
+		return fmt.Errorf("upsert preview: %w", err)
+	}
+	s.AfterCommit(func() { EnqueueBuild(blog.ID, label) })
+	sesh.Printf(
+		"preview of `%s' at %s\n",
+		branch, PreviewURL(label, blog.Subdomain),
+	)
+	return nil
+}
+
+// DeletePreview takes down the preview of a deleted branch. Its files are left
+// for garbage collection along with those of other stale generations.
+func DeletePreview(blog *model.Blog, branch string, s *model.Store) error {
+	return s.ExecTx(func(tx *model.Store) error {
+		if err := tx.MarkPreviewGenerationsStale(
+			context.TODO(),
+			model.MarkPreviewGenerationsStaleParams{
+				Blog:   blog.ID,
+				Branch: branch,
+			},
+		); err != nil {
+			return fmt.Errorf("mark stale: %w", err)
+		}
+		if err := tx.DeletePreviewByBranch(
+			context.TODO(),
+			model.DeletePreviewByBranchParams{
+				Blog:   blog.ID,
+				Branch: branch,
+			},
+		); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		return nil
+	})
+}
+
+/* deletePreviewsNotMatching takes down the previews of branches that the
+ * blog no longer previews */
+func deletePreviewsNotMatching(
+	blogID, patterns string, s *model.Store,
+) error {
+	previews, err := s.ListPreviewsByBlog(context.TODO(), blogID)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	for _, p := range previews {
+		if previewbranches.Match(patterns, p.Branch) {
+			continue
+		}
+		if err := s.MarkPreviewGenerationsStale(
+			context.TODO(),
+			model.MarkPreviewGenerationsStaleParams{
+				Blog:   blogID,
+				Branch: p.Branch,
+			},
+		); err != nil {
+			return fmt.Errorf("mark stale: %w", err)
+		}
+		if err := s.DeletePreviewByBranch(
+			context.TODO(),
+			model.DeletePreviewByBranchParams{
+				Blog:   blogID,
+				Branch: p.Branch,
+			},
+		); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+	return nil
+}
+
+func PreviewURL(label string, sub *dns.Subdomain) string {
+	return fmt.Sprintf(
+		"%s://%s",
+		config.Config.Hylodoc.Protocol,
+		dns.PreviewHost(label, sub, config.Config.Hylodoc.RootDomain),
+	)
+}
+
+type PreviewInfo struct {
+	Branch    string
+	Url       string
+	Hash      string
+	UpdatedAt time.Time
+}
+
+func getPreviewsInfo(s *model.Store, blogID string) ([]PreviewInfo, error) {
+	blog, err := s.GetBlogByID(context.TODO(), blogID)
+	if err != nil {
+		return nil, fmt.Errorf("get blog: %w", err)
+	}
+	previews, err := s.ListPreviewsByBlog(context.TODO(), blogID)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	info := make([]PreviewInfo, len(previews))
+	for i, p := range previews {
+		info[i] = PreviewInfo{
+			Branch:    p.Branch,
+			Url:       PreviewURL(p.Label, blog.Subdomain),
+			Hash:      p.Hash,
+			UpdatedAt: p.UpdatedAt,
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,102 @@
+package blog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/precompress"
+	"github.com/hylodoc/hylodoc.com/internal/sitemap"
+	"github.com/hylodoc/hylodoc/pkg/ssg"
+)
+
+/* pages we add to every site that don't belong in its sitemap */
+var unlisted = map[string]bool{
+	"/subscribed":   true,
+	"/unsubscribed": true,
+	NotFoundURL:     true,
+}
+
+/* generateSitemap writes the site's sitemap.xml and robots.txt into dst and
+ * returns the bindings for them. A robots.txt in src is used as is, otherwise
+ * a default one pointing to the sitemap is written. */
+func generateSitemap(
+	site ssg.Site, b *model.Blog, src, link, dst string,
+) (map[string]string, error) {
+	commitTime, err := getCommitTime(b)
+	if err != nil {
+		return nil, fmt.Errorf("commit time: %w", err)
+	}
+	var urls []sitemap.URL
+	for url, rsc := range site.Bindings() {
+		if filepath.Ext(rsc.Path()) != ".html" || unlisted[url] {
+			continue
+		}
+		lastmod := commitTime
+		if rsc.IsPost() {
+			if t, ok := rsc.Post().Time(); ok {
+				lastmod = t
+			}
+		}
+		urls = append(urls, sitemap.URL{Loc: link + url, LastMod: lastmod})
+	}
+	xml, err := sitemap.Render(urls)
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	robots, err := os.ReadFile(filepath.Join(src, "robots.txt"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read robots.txt: %w", err)
+		}
+		robots = sitemap.Robots(link)
+	}
+	return writeFiles(dst, map[string][]byte{
+		sitemap.SitemapPath: xml,
+		sitemap.RobotsPath:  robots,
+	})
+}
+
+/* generatePreviewRobots writes a robots.txt that keeps crawlers out of a
+ * preview, which has no sitemap */
+func generatePreviewRobots(dst string) (map[string]string, error) {
+	return writeFiles(dst, map[string][]byte{
+		sitemap.RobotsPath: sitemap.NoRobots(),
+	})
+}
+
+func getCommitTime(b *model.Blog) (time.Time, error) {
+	repo, err := git.PlainOpen(checkoutPath(b))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("open: %w", err)
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(b.LiveHash.String))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("commit: %w", err)
+	}
+	return commit.Committer.When, nil
+}
+
+/* writeFiles writes files (keyed by URL) into dst, which is the root of the
+ * generated site, and returns their bindings */
+func writeFiles(
+	dst string, files map[string][]byte,
+) (map[string]string, error) {
+	bindings := map[string]string{}
+	for url, b := range files {
+		path := filepath.Join(dst, filepath.FromSlash(url))
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			return nil, fmt.Errorf("write %s: %w", url, err)
+		}
+		if err := precompress.CompressFile(path); err != nil {
+			return nil, fmt.Errorf("precompress %s: %w", url, err)
+		}
+		bindings[url] = path
+	}
+	return bindings, nil
+}
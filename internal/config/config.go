@@ -21,6 +21,9 @@ var Config Configuration
 type Configuration struct {
 	Hylodoc          HylodocParams    `mapstructure:"hylodoc"`
 	SSG       SSGParams `mapstructure:"ssg"`
+	Git       GitParams `mapstructure:"git"`
+	Routing            RoutingParams      `mapstructure:"routing"`
+	Feeds              FeedsParams        `mapstructure:"feeds"`
 	Github             GithubParams       `mapstructure:"github"`
 	Db                 DbParams           `mapstructure:"postgres"`
 	Email              EmailParams        `mapstructure:"email"`
@@ -37,6 +40,7 @@ type HylodocParams struct {
 	GitdirsPath          string `mapstructure:"gitdirs_path"`
 	CertsPath            string `mapstructure:"certs_path"`
 	WebsitesPath         string `mapstructure:"websites_path"`
+	ManifestsPath        string `mapstructure:"manifests_path"`
 	EmailDomain          string `mapstructure:"email_domain"`
 	AccountsEmail        string `mapstructure:"accounts_email"`
 	CustomDomainCNAME    string `mapstructure:"custom_domain_cname"`
@@ -47,8 +51,80 @@ type HylodocParams struct {
 	OpenIssueURL         string `mapstructure:"open_issue_url"`
 }
 
+type RoutingParams struct {
+	/* how long a host's blog and generation are cached, which bounds how
+	 * long other instances serve them after a change */
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	/* Cache-Control max-age for non-HTML files on user sites, after which
+	 * they revalidate by ETag; HTML and feeds always revalidate. Assets
+	 * keep their URLs across generations, so it bounds how long readers
+	 * see a replaced one. */
+	AssetMaxAge time.Duration   `mapstructure:"asset_max_age"`
+	RateLimit   RateLimitParams `mapstructure:"rate_limit"`
+	Visits      VisitsParams    `mapstructure:"visits"`
+}
+
+/* visits are buffered in memory and written to the db in batches */
+type VisitsParams struct {
+	/* visits held before new ones are dropped */
+	BufferSize int `mapstructure:"buffer_size"`
+	/* visits written per batch; a full batch is flushed immediately */
+	BatchSize int `mapstructure:"batch_size"`
+	/* longest a visit waits in the buffer */
+	FlushPeriod time.Duration `mapstructure:"flush_period"`
+	/* how often visits are rolled up into the daily totals on the
+	 * dashboard */
+	RollupPeriod time.Duration `mapstructure:"rollup_period"`
+	/* how long raw visits are kept after being rolled up */
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+type RateLimitParams struct {
+	/* every request, by client IP */
+	IP RateLimit `mapstructure:"ip"`
+	/* user-site requests, by blog */
+	Blog RateLimit `mapstructure:"blog"`
+	/* subscription requests, by client IP */
+	Subscribe RateLimit `mapstructure:"subscribe"`
+}
+
+/* a token bucket; a non-positive rate disables the limit */
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate"` /* requests per second */
+	Burst int     `mapstructure:"burst"`
+}
+
+/* defaults for blogs that haven't set their own */
+type FeedsParams struct {
+	/* number of most recent posts in each feed */
+	Entries int `mapstructure:"entries"`
+	/* whether entries carry the post's HTML or only a plaintext summary */
+	FullContent bool `mapstructure:"full_content"`
+}
+
 type SSGParams struct {
 	Themes map[string]Theme `mapstructure:"themes"`
+	/* blogs generated at once in the background */
+	Workers int `mapstructure:"workers"`
+	/* blogs waiting to be generated before new ones are dropped */
+	QueueSize int      `mapstructure:"queue_size"`
+	GC        GCParams `mapstructure:"gc"`
+}
+
+/* generations, website directories and checkouts that can no longer be served
+ * are deleted periodically */
+type GCParams struct {
+	Period time.Duration `mapstructure:"period"`
+	/* how long garbage is kept after it stops being referenced */
+	Grace time.Duration `mapstructure:"grace"`
+	/* log what would be deleted without deleting it */
+	DryRun bool `mapstructure:"dry_run"`
+}
+
+type GitParams struct {
+	/* commits fetched of each branch, 0 fetching all of them. Only those
+	 * fetched can be pinned. */
+	Depth int `mapstructure:"depth"`
 }
 
 type Theme struct {
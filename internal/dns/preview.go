@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/* PreviewSeparator separates a branch preview's label from the subdomain of
+ * its blog in the host it is served at. Subdomains cannot contain consecutive
+ * hyphens, so the host splits unambiguously at the first occurrence. */
+const PreviewSeparator = "--"
+
+// PreviewLabel converts a branch name to the label of its preview, which
+// together with the separator and the subdomain must fit in a DNS label. A
+// branch whose name isn't already a valid label (such as feature/a) has a hash
+// of the name appended, so that it doesn't share a label with another branch
+// (such as feature-a).
+func PreviewLabel(branch string, sub *Subdomain) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToLower(branch) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			b.WriteRune(r)
+		} else if !strings.HasSuffix(b.String(), "-") {
+			b.WriteRune('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	room := 63 - len(PreviewSeparator) - len(sub.String())
+	if room < 1 {
+		return "", fmt.Errorf("subdomain too long for previews")
+	}
+	if label == branch && len(label) <= room {
+		return label, nil
+	}
+	sum := sha256.Sum256([]byte(branch))
+	suffix := hex.EncodeToString(sum[:])[:previewHashLen]
+	room -= len(suffix) + 1
+	if room < 1 {
+		return "", fmt.Errorf("subdomain too long for previews")
+	}
+	if len(label) > room {
+		label = strings.TrimRight(label[:room], "-")
+	}
+	if label == "" {
+		return suffix, nil
+	}
+	return label + "-" + suffix, nil
+}
+
+/* hex digits of the hash of a branch name appended to its label */
+const previewHashLen = 6
+
+// PreviewHost returns the host, under the given root domain, that the preview
+// with the given label is served at.
+func PreviewHost(label string, sub *Subdomain, root string) string {
+	return fmt.Sprintf("%s%s%s.%s", label, PreviewSeparator, sub, root)
+}
@@ -0,0 +1,231 @@
+// Package feed renders a blog's posts as RSS 2.0, Atom and JSON Feed
+// documents.
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+type Feed struct {
+	Title string
+	/* absolute URL of the site, without a trailing slash */
+	Link    string
+	Updated time.Time
+	Entries []Entry
+}
+
+// An Entry is a post in a feed. If Content (HTML) is empty the entry only
+// carries its Summary (plaintext).
+type Entry struct {
+	Title     string
+	Link      string
+	Published time.Time
+	Summary   string
+	Content   string
+}
+
+const (
+	RSSPath  = "/feed.xml"
+	AtomPath = "/atom.xml"
+	JSONPath = "/feed.json"
+)
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func (f *Feed) RSS() ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title: f.Title,
+			Link:  f.Link + "/",
+			Self: atomLink{
+				Href: f.Link + RSSPath,
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+			Description:   f.Title,
+			LastBuildDate: f.Updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{true, e.Link},
+			Description: e.body(),
+		}
+		if !e.Published.IsZero() {
+			item.PubDate = e.Published.Format(time.RFC1123Z)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return marshalxml(doc)
+}
+
+type atom struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"xml:base,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Link      atomLink  `xml:"link"`
+	Published string    `xml:"published,omitempty"`
+	Updated   string    `xml:"updated"`
+	Summary   *atomText `xml:"summary,omitempty"`
+	Content   *atomText `xml:"content,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func (f *Feed) Atom() ([]byte, error) {
+	doc := atom{
+		Base:    f.Link + "/",
+		ID:      f.Link + "/",
+		Title:   f.Title,
+		Updated: f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Link + "/", Rel: "alternate"},
+			{Href: f.Link + AtomPath, Rel: "self"},
+		},
+	}
+	for _, e := range f.Entries {
+		/* Atom requires every entry to have an update time */
+		updated := e.Published
+		if updated.IsZero() {
+			updated = f.Updated
+		}
+		entry := atomEntry{
+			ID:      e.Link,
+			Title:   e.Title,
+			Link:    atomLink{Href: e.Link, Rel: "alternate"},
+			Updated: updated.Format(time.RFC3339),
+		}
+		if !e.Published.IsZero() {
+			entry.Published = e.Published.Format(time.RFC3339)
+		}
+		if e.Summary != "" {
+			entry.Summary = &atomText{"text", e.Summary}
+		}
+		if e.Content != "" {
+			entry.Content = &atomText{"html", e.Content}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalxml(doc)
+}
+
+type jsonfeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	Summary       string `json:"summary,omitempty"`
+	ContentHTML   string `json:"content_html,omitempty"`
+	ContentText   string `json:"content_text,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+}
+
+func (f *Feed) JSON() ([]byte, error) {
+	doc := jsonfeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link + "/",
+		FeedURL:     f.Link + JSONPath,
+		Items:       []jsonItem{},
+	}
+	for _, e := range f.Entries {
+		item := jsonItem{
+			ID:    e.Link,
+			URL:   e.Link,
+			Title: e.Title,
+		}
+		/* every item must have content_html or content_text */
+		if e.Content != "" {
+			item.Summary = e.Summary
+			item.ContentHTML = e.Content
+		} else {
+			item.ContentText = e.Summary
+		}
+		if !e.Published.IsZero() {
+			item.DatePublished = e.Published.Format(time.RFC3339)
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *Entry) body() string {
+	if e.Content != "" {
+		return e.Content
+	}
+	return e.Summary
+}
+
+func marshalxml(v any) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,80 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+var f = Feed{
+	Title:   "Notes & Essays",
+	Link:    "https://notes.example.com",
+	Updated: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	Entries: []Entry{
+		{
+			Title:     "First",
+			Link:      "https://notes.example.com/first",
+			Published: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Summary:   "A summary.",
+			Content:   "<p>The <em>full</em> post.</p>",
+		},
+		{
+			Title:   "Undated",
+			Link:    "https://notes.example.com/undated",
+			Summary: "No date.",
+		},
+	},
+}
+
+func TestRSS(t *testing.T) {
+	b, err := f.RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc rss
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	items := doc.Channel.Items
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(items))
+	}
+	if items[0].Description != f.Entries[0].Content {
+		t.Errorf("expected full content, got %q", items[0].Description)
+	}
+	if items[1].PubDate != "" {
+		t.Errorf("expected no date for undated entry")
+	}
+}
+
+func TestAtom(t *testing.T) {
+	b, err := f.Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc atom
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := doc.Entries[1].Updated; got != "2024-01-02T00:00:00Z" {
+		t.Errorf("expected undated entry to use feed time, got %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := f.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonfeed
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc.Items[0].ContentHTML != f.Entries[0].Content {
+		t.Errorf("expected content_html, got %q", doc.Items[0].ContentHTML)
+	}
+	if doc.Items[1].ContentText != f.Entries[1].Summary {
+		t.Errorf("expected content_text, got %q", doc.Items[1].ContentText)
+	}
+}
@@ -0,0 +1,294 @@
+// Package gc deletes what builds leave behind once nothing can serve it: the
+// generations superseded by newer ones along with their rows and website
+// directories, the website directories of failed builds and deleted blogs, and
+// the checkouts of commits that will never be generated again.
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+// Paths are the directories that garbage is collected in.
+type Paths struct {
+	Websites  string
+	Checkouts string
+	Manifests string
+}
+
+/* what a run deleted, or would have in a dry run */
+type report struct {
+	gens  int
+	files int
+	bytes int64
+}
+
+// Run collects garbage every period. Anything must have been unreferenced for
+// grace before it is deleted, so that requests and builds in progress aren't
+// pulled out from under. In a dry run nothing is deleted, but what would be is
+// logged. Run only returns if its arguments are invalid; failed runs are
+// retried next period.
+func Run(
+	s *model.Store, paths Paths, period, grace time.Duration, dryRun bool,
+) error {
+	if period <= 0 {
+		return fmt.Errorf("no gc period")
+	}
+	if grace <= 0 {
+		return fmt.Errorf("no grace")
+	}
+	for {
+		r, err := collect(s, paths, time.Now().Add(-grace), dryRun)
+		if err != nil {
+			log.Println("gc:", err)
+		}
+		if r.gens > 0 || r.files > 0 {
+			log.Printf(
+				"gc: %s %d generations and %d files and directories, reclaiming %d bytes\n",
+				verb(dryRun), r.gens, r.files, r.bytes,
+			)
+		}
+		time.Sleep(period)
+	}
+}
+
+func verb(dryRun bool) string {
+	if dryRun {
+		return "would delete"
+	}
+	return "deleted"
+}
+
+/* collect deletes the garbage unreferenced since before cutoff. The report
+ * covers what was deleted even if it fails part way. */
+func collect(
+	s *model.Store, paths Paths, cutoff time.Time, dryRun bool,
+) (report, error) {
+	var r report
+	collectable, err := s.ListCollectableGenerations(context.TODO(), cutoff)
+	if err != nil {
+		return r, fmt.Errorf("list collectable generations: %w", err)
+	}
+	collected := map[int32]bool{}
+	for _, gen := range collectable {
+		if !dryRun {
+			if err := deleteGeneration(gen.ID, s); err != nil {
+				return r, fmt.Errorf(
+					"delete generation %d: %w", gen.ID, err,
+				)
+			}
+		}
+		collected[gen.ID] = true
+		r.gens++
+	}
+
+	/* in a dry run the generations collected are still listed */
+	gens, err := s.ListGenerations(context.TODO())
+	if err != nil {
+		return r, fmt.Errorf("list generations: %w", err)
+	}
+	keepgens := map[int32]bool{}
+	keepdirs := map[string]bool{}
+	for _, gen := range gens {
+		if !collected[gen.ID] {
+			keepgens[gen.ID] = true
+			keepdirs[filepath.Clean(gen.Dir)] = true
+		}
+	}
+	if err := sweepManifests(
+		paths.Manifests, keepgens, cutoff, dryRun, &r,
+	); err != nil {
+		return r, fmt.Errorf("manifests: %w", err)
+	}
+	if err := sweep(
+		paths.Websites, 2, keepdirs, cutoff, dryRun, &r,
+	); err != nil {
+		return r, fmt.Errorf("websites: %w", err)
+	}
+
+	hashes, err := s.ListCheckedOutHashes(context.TODO())
+	if err != nil {
+		return r, fmt.Errorf("list hashes: %w", err)
+	}
+	keepdirs = map[string]bool{}
+	for _, hash := range hashes {
+		keepdirs[filepath.Join(paths.Checkouts, hash)] = true
+	}
+	if err := sweep(
+		paths.Checkouts, 1, keepdirs, cutoff, dryRun, &r,
+	); err != nil {
+		return r, fmt.Errorf("checkouts: %w", err)
+	}
+	return r, nil
+}
+
+func deleteGeneration(gen int32, s *model.Store) error {
+	return s.ExecTx(func(tx *model.Store) error {
+		if err := tx.DeleteGenerationPostEmailBindings(
+			context.TODO(), gen,
+		); err != nil {
+			return fmt.Errorf("post email bindings: %w", err)
+		}
+		if err := tx.DeleteGenerationPosts(context.TODO(), gen); err != nil {
+			return fmt.Errorf("posts: %w", err)
+		}
+		if err := tx.DeleteGenerationBindings(
+			context.TODO(), gen,
+		); err != nil {
+			return fmt.Errorf("bindings: %w", err)
+		}
+		if err := tx.DeleteGenerationRedirects(
+			context.TODO(), gen,
+		); err != nil {
+			return fmt.Errorf("redirects: %w", err)
+		}
+		return tx.DeleteGeneration(context.TODO(), gen)
+	})
+}
+
+/* sweepManifests deletes the manifests of generations that have been
+ * collected, which would otherwise serve missing files were the DB to become
+ * unavailable. A host gets a new manifest on its next request. */
+func sweepManifests(
+	root string, keep map[int32]bool, cutoff time.Time, dryRun bool,
+	r *report,
+) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(root, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		var m struct{ Generation int32 }
+		/* an unreadable manifest can't be served from either */
+		if err := json.Unmarshal(b, &m); err == nil && keep[m.Generation] {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("remove %s: %w", path, err)
+			}
+		}
+		log.Printf("gc: %s %s (%d bytes)\n", verb(dryRun), path, len(b))
+		r.files++
+		r.bytes += int64(len(b))
+	}
+	return nil
+}
+
+/* sweep deletes the directories depth levels below root that aren't in keep
+ * and haven't been modified since cutoff, along with the directories left
+ * empty above them */
+func sweep(
+	root string, depth int, keep map[string]bool, cutoff time.Time,
+	dryRun bool, r *report,
+) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(root, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		/* modified since cutoff, so possibly being written to */
+		recent := info.ModTime().After(cutoff)
+		if depth > 1 {
+			if err := sweep(
+				path, depth-1, keep, cutoff, dryRun, r,
+			); err != nil {
+				return err
+			}
+			if !dryRun && !recent {
+				removeIfEmpty(path)
+			}
+			continue
+		}
+		if keep[path] || recent {
+			continue
+		}
+		size, err := dirSize(path)
+		if err != nil {
+			return fmt.Errorf("size of %s: %w", path, err)
+		}
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("remove %s: %w", path, err)
+			}
+		}
+		log.Printf("gc: %s %s (%d bytes)\n", verb(dryRun), path, size)
+		r.files++
+		r.bytes += size
+	}
+	return nil
+}
+
+func removeIfEmpty(path string) {
+	if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
+		os.Remove(path)
+	}
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(
+		path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		},
+	)
+	return size, err
+}
@@ -0,0 +1,112 @@
+package gc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mkdir(t *testing.T, path string, mtime time.Time, files ...string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := os.WriteFile(
+			filepath.Join(path, f), []byte("hello"), 0644,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestSweep(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	old, recent := cutoff.Add(-time.Hour), time.Now()
+
+	for _, dryRun := range []bool{false, true} {
+		root := t.TempDir()
+		kept := filepath.Join(root, "a", "kept")
+		garbage := filepath.Join(root, "a", "garbage")
+		building := filepath.Join(root, "a", "building")
+		deleted := filepath.Join(root, "b", "garbage")
+		mkdir(t, kept, old, "index.html")
+		mkdir(t, garbage, old, "index.html", "index.html.gz")
+		mkdir(t, building, recent, "index.html")
+		mkdir(t, deleted, old, "index.html")
+		mkdir(t, filepath.Join(root, "a"), old)
+		mkdir(t, filepath.Join(root, "b"), old)
+
+		var r report
+		if err := sweep(
+			root, 2, map[string]bool{kept: true}, cutoff, dryRun, &r,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if r.files != 2 || r.bytes != 15 {
+			t.Errorf(
+				"dry run %v: got %d dirs of %d bytes, want 2 of 15",
+				dryRun, r.files, r.bytes,
+			)
+		}
+		for path, want := range map[string]bool{
+			kept:                     true,
+			building:                 true,
+			garbage:                  dryRun,
+			deleted:                  dryRun,
+			filepath.Join(root, "b"): dryRun,
+		} {
+			if got := exists(path); got != want {
+				t.Errorf(
+					"dry run %v: %s exists %v, want %v",
+					dryRun, path, got, want,
+				)
+			}
+		}
+	}
+}
+
+func TestSweepManifests(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	old := cutoff.Add(-time.Hour)
+
+	root := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	kept := write("kept.com.json", `{"Generation":1}`)
+	collected := write("collected.com.json", `{"Generation":2}`)
+	temp := write(".manifest-123", `{"Generation":2}`)
+
+	var r report
+	if err := sweepManifests(
+		root, map[int32]bool{1: true}, cutoff, false, &r,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if r.files != 1 {
+		t.Errorf("got %d files, want 1", r.files)
+	}
+	if !exists(kept) || !exists(temp) {
+		t.Error("deleted manifest in use")
+	}
+	if exists(collected) {
+		t.Error("kept manifest of collected generation")
+	}
+}
@@ -31,6 +31,7 @@ type PushEvent struct {
 	Ref          string       `json:"ref"`
 	Before       string       `json:"before"`
 	After        string       `json:"after"`
+	Deleted      bool         `json:"deleted"`
 	Repository   Repository   `json:"repository"`
 	Pusher       User         `json:"pusher"`
 	Sender       User         `json:"sender"`
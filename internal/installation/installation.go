@@ -509,11 +509,22 @@ func handlePush(
 	sesh.Printf("live branch: `%s'\n", b.LiveBranch)
 
 	if branchName != b.LiveBranch {
-		/* event does not match live branch */
+		/* pushes to other branches may be previewed */
+		if event.Deleted {
+			if err := blog.DeletePreview(&b, branchName, s); err != nil {
+				return fmt.Errorf("error deleting preview: %w", err)
+			}
+			return nil
+		}
+		if err := blog.UpdatePreviewOnDisk(
+			c, &b, branchName, sesh, s,
+		); err != nil {
+			return fmt.Errorf("error updating preview: %w", err)
+		}
 		return nil
 	}
 
-	if err := blog.UpdateRepositoryOnDisk(c, &b, sesh, s); err != nil {
+	if err := blog.UpdateRepositoryOnPush(c, &b, sesh, s); err != nil {
 		return fmt.Errorf("error pulling latest changes: %w", err)
 	}
 	return nil
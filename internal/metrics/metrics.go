@@ -47,6 +47,29 @@ var (
 		[]string{"method", "path", "status", "error_type"},
 	)
 
+	httpRequestRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_rate_limited",
+			Help: "hylodoc service request rejected by rate limiting",
+		},
+		[]string{"limiter"},
+	)
+
+	/* user site visits */
+	visitsFlushed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "visits_flushed",
+			Help: "visits written to the db",
+		},
+	)
+	visitsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "visits_dropped",
+			Help: "visits discarded without being written to the db",
+		},
+		[]string{"reason"},
+	)
+
 	/* downstream metrics */
 	httpClientRequest = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -123,6 +146,10 @@ func Initialize() {
 	prometheus.MustRegister(httpRequestSuccess)
 	prometheus.MustRegister(httpRequestErrors)
 	prometheus.MustRegister(httpRequestDuration)
+	prometheus.MustRegister(httpRequestRateLimited)
+
+	prometheus.MustRegister(visitsFlushed)
+	prometheus.MustRegister(visitsDropped)
 
 	prometheus.MustRegister(httpClientRequest)
 	prometheus.MustRegister(httpClientSuccess)
@@ -219,6 +246,8 @@ func classifyError(statusCode int, sesh *session.Session) string {
 		return "not_found"
 	case statusCode == http.StatusUnauthorized:
 		return "unauthorized"
+	case statusCode == http.StatusTooManyRequests:
+		return "rate_limited"
 	case statusCode >= 500:
 		return "internal"
 	default:
@@ -231,6 +260,18 @@ func Handler() http.Handler {
 	return promhttp.Handler()
 }
 
+func RecordRateLimited(limiter string) {
+	httpRequestRateLimited.WithLabelValues(limiter).Inc()
+}
+
+func RecordVisitsFlushed(n int) {
+	visitsFlushed.Add(float64(n))
+}
+
+func RecordVisitsDropped(reason string, n int) {
+	visitsDropped.WithLabelValues(reason).Add(float64(n))
+}
+
 func RecordClientRequest(method, url string) {
 	httpClientRequest.WithLabelValues(method, url).Inc()
 }
@@ -47,6 +47,31 @@ UPDATE blogs
 SET live_hash = @live_hash::VARCHAR
 WHERE id = $1;
 
+-- name: UpdateBlogHeadHash :exec
+-- the live hash follows the head unless the blog is pinned
+UPDATE blogs
+SET
+	head_hash = @head_hash::VARCHAR,
+	live_hash = CASE
+		WHEN pinned THEN live_hash
+		ELSE @head_hash::VARCHAR
+	END
+WHERE id = $1;
+
+-- name: PinBlog :exec
+UPDATE blogs
+SET
+	pinned = true,
+	live_hash = @live_hash::VARCHAR
+WHERE id = $1;
+
+-- name: UnpinBlog :exec
+UPDATE blogs
+SET
+	pinned = false,
+	live_hash = COALESCE(head_hash, live_hash)
+WHERE id = $1;
+
 -- name: CheckBlogOwnership :one
 SELECT EXISTS (
 	SELECT 1
@@ -95,11 +120,26 @@ UPDATE blogs
 SET is_live = false
 WHERE id = $1;
 
+-- name: SetBlogOfflineMessage :exec
+UPDATE blogs
+SET offline_message = $1
+WHERE id = $2;
+
 -- name: SetBlogEmailMode :exec
 UPDATE blogs
 SET email_mode = $1
 WHERE id = $2;
 
+-- name: SetBlogStatsToken :exec
+UPDATE blogs
+SET stats_token = $1
+WHERE id = $2;
+
+-- name: GetBlogByStatsToken :one
+SELECT *
+FROM blogs
+WHERE stats_token = $1;
+
 -- name: ListBlogsForInstallationByGhInstallationID :many
 SELECT b.*
 FROM blogs b
@@ -124,6 +164,25 @@ SET
 	live_branch = $1
 WHERE id = $2;
 
+-- name: SetContentRootByID :exec
+UPDATE blogs
+SET
+	content_root = $1
+WHERE id = $2;
+
+-- name: SetPreviewBranchesByID :exec
+UPDATE blogs
+SET
+	preview_branches = $1
+WHERE id = $2;
+
+-- name: SetFeedSettingsByID :exec
+UPDATE blogs
+SET
+	feed_entries = $1,
+	feed_full_content = $2
+WHERE id = $3;
+
 -- name: DeleteBlogByID :exec
 DELETE
 FROM blogs
@@ -1,3 +1,7 @@
 -- name: Boot :one
-INSERT INTO boots DEFAULT VALUES
+INSERT INTO boots (
+	instance
+) VALUES (
+	$1
+)
 RETURNING id;
@@ -0,0 +1,83 @@
+-- name: InsertBuild :one
+INSERT INTO builds (
+	blog, label, hash, theme, content_root, boot_id
+) VALUES (
+	$1, $2, $3, $4, $5, $6
+)
+RETURNING id;
+
+-- name: SucceedBuild :exec
+UPDATE builds
+SET
+	status = 'succeeded',
+	finished_at = now(),
+	gen = $2,
+	log = $3
+WHERE id = $1;
+
+-- name: FailBuild :exec
+UPDATE builds
+SET
+	status = 'failed',
+	finished_at = now(),
+	log = $2
+WHERE id = $1;
+
+-- name: InterruptBuilds :exec
+-- the builds left building by the instance's earlier boots
+UPDATE builds
+SET
+	status = 'interrupted',
+	finished_at = now(),
+	log = 'error: interrupted' || E'\n'
+WHERE status = 'building'
+	AND boot_id IN (
+		SELECT id
+		FROM boots
+		WHERE instance = @instance AND id <> @boot_id
+	);
+
+-- name: DeleteOldBuilds :exec
+-- all but the blog's latest builds
+DELETE FROM builds
+WHERE blog = @blog AND id NOT IN (
+	SELECT id
+	FROM builds
+	WHERE blog = @blog
+	ORDER BY id DESC
+	LIMIT @keep
+);
+
+-- name: GetBuild :one
+SELECT *
+FROM builds
+WHERE id = $1 AND blog = $2;
+
+-- name: ListBuildsByBlog :many
+SELECT *
+FROM builds
+WHERE blog = $1
+ORDER BY id DESC
+LIMIT $2;
+
+-- name: GetCurrentBuildStatus :one
+-- the status of the latest build of the blog, or of its preview with the given
+-- label, at its current commit, theme and content root
+SELECT bu.status
+FROM builds bu
+INNER JOIN blogs b ON b.id = bu.blog
+LEFT JOIN previews p ON p.blog = bu.blog AND p.label = bu.label
+WHERE bu.blog = @blog AND bu.label = @label
+	AND bu.hash = CASE WHEN bu.label = '' THEN b.live_hash ELSE p.hash END
+	AND bu.theme = b.theme
+	AND bu.content_root = b.content_root
+ORDER BY bu.id DESC
+LIMIT 1;
+
+-- name: IsBlogBuilding :one
+-- whether the live site of the blog is being built on any instance
+SELECT EXISTS (
+	SELECT 1
+	FROM builds
+	WHERE blog = $1 AND label = '' AND status = 'building'
+);
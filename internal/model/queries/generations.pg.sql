@@ -1,11 +1,16 @@
 -- name: InsertGeneration :one
 INSERT INTO generations (
-	hash, boot_id
+	hash, boot_id, blog, dir, title
 ) VALUES (
-	$1, (SELECT id FROM boot_id)
+	$1, (SELECT id FROM boot_id), sqlc.arg(blog)::TEXT, $2, $3
 )
 RETURNING id;
 
+-- name: GetGenerationTitle :one
+SELECT title
+FROM generations
+WHERE id = $1;
+
 -- name: GetFreshGeneration :one
 SELECT g.id
 FROM generations g
@@ -14,21 +19,163 @@ INNER JOIN blogs b
 WHERE b.id = @blog_id
 	AND g.boot_id = (SELECT id FROM boot_id)
 	AND g.stale = false
+	AND g.preview = false
+LIMIT 1;
+
+-- name: GetFreshGenerationByHash :one
+SELECT id
+FROM generations
+WHERE blog = $1 AND hash = $2
+	AND boot_id = (SELECT id FROM boot_id)
+	AND stale = false
+	AND preview = false
 LIMIT 1;
 
+-- name: InsertPreviewGeneration :one
+INSERT INTO generations (
+	hash, boot_id, preview, blog, label, dir
+) VALUES (
+	$1, (SELECT id FROM boot_id), true, sqlc.arg(blog)::TEXT, $2, $3
+)
+RETURNING id;
+
+-- name: GetFreshPreviewGeneration :one
+SELECT g.id
+FROM generations g
+INNER JOIN previews p
+	ON p.blog = g.blog AND p.label = g.label AND p.hash = g.hash
+WHERE p.blog = $1 AND p.label = $2
+	AND g.boot_id = (SELECT id FROM boot_id)
+	AND g.stale = false
+	AND g.preview = true
+LIMIT 1;
+
+-- name: GetLatestGeneration :one
+-- the most recently completed generation of the blog, or of its preview with
+-- the given label, whether fresh or not. Only generations of the live hash
+-- are served while the blog is pinned.
+SELECT g.id
+FROM generations g
+INNER JOIN blogs b ON b.id = g.blog
+WHERE g.blog = $1 AND g.label = $2
+	AND (g.preview OR NOT b.pinned OR g.hash = b.live_hash)
+ORDER BY g.id DESC
+LIMIT 1;
+
+-- name: TryLockBlogBuilds :one
+-- a session lock (in class 1, that of builds) on generating the blog, which
+-- must be released on the same connection. Returns false at once if the lock
+-- is held elsewhere.
+SELECT pg_try_advisory_lock(1, hashtext(sqlc.arg(blog)::TEXT));
+
+-- name: UnlockBlogBuilds :one
+SELECT pg_advisory_unlock(1, hashtext(sqlc.arg(blog)::TEXT));
+
 -- name: MarkBlogGenerationsStale :exec
-UPDATE generations g
+-- includes the head's generation of a pinned blog
+UPDATE generations
 SET stale = true
-FROM blogs b
-WHERE b.live_hash = g.hash
-AND b.id = $1;
+WHERE blog = sqlc.arg(id)::TEXT AND preview = false;
 
 -- name: MarkGenerationsStaleByStripeSubscriptionID :exec
 UPDATE generations g
 SET stale = true
 FROM stripe_subscriptions s
 	INNER JOIN blogs b ON b.user_id = s.user_id
-WHERE s.stripe_subscription_id = $1 AND b.live_hash = g.hash;
+WHERE s.stripe_subscription_id = $1 AND g.blog = b.id AND g.preview = false;
+
+-- name: ListCollectableGenerations :many
+-- generations that can no longer be served: those superseded before the
+-- cutoff, and those of deleted blogs and previews created before it. The
+-- latest generation of each site is kept, as are the fresh generations of
+-- its commits and the latest of the head of a pinned blog.
+WITH kept AS (
+	(SELECT DISTINCT ON (g.blog, g.label) g.id
+	FROM generations g
+	INNER JOIN blogs b ON b.id = g.blog
+	LEFT JOIN previews p ON p.blog = g.blog AND p.label = g.label
+	WHERE (g.preview AND p.blog IS NOT NULL)
+		OR (NOT g.preview AND (NOT b.pinned OR g.hash = b.live_hash))
+	ORDER BY g.blog, g.label, g.id DESC)
+	UNION
+	(SELECT DISTINCT ON (g.blog) g.id
+	FROM generations g
+	INNER JOIN blogs b ON b.id = g.blog AND b.head_hash = g.hash
+	WHERE b.pinned AND NOT g.preview
+	ORDER BY g.blog, g.id DESC)
+	UNION
+	SELECT g.id
+	FROM generations g
+	INNER JOIN blogs b ON b.id = g.blog
+	WHERE NOT g.preview AND g.hash IN (b.live_hash, b.head_hash)
+		AND g.boot_id = (SELECT id FROM boot_id)
+		AND g.stale = false
+	UNION
+	SELECT g.id
+	FROM generations g
+	INNER JOIN previews p
+		ON p.blog = g.blog AND p.label = g.label AND p.hash = g.hash
+	WHERE g.preview
+		AND g.boot_id = (SELECT id FROM boot_id)
+		AND g.stale = false
+)
+SELECT g.id, g.dir
+FROM generations g
+LEFT JOIN blogs b ON b.id = g.blog
+LEFT JOIN previews p ON p.blog = g.blog AND p.label = g.label
+WHERE g.id NOT IN (SELECT id FROM kept)
+	AND (
+		(
+			(b.id IS NULL OR (g.preview AND p.blog IS NULL))
+			AND g.created_at < @before
+		)
+		OR EXISTS (
+			SELECT 1
+			FROM generations n
+			WHERE n.blog = g.blog AND n.label = g.label
+				AND n.id > g.id
+				AND n.created_at < @before
+		)
+	)
+ORDER BY g.id;
+
+-- name: ListGenerations :many
+SELECT id, dir
+FROM generations;
+
+-- name: DeleteGeneration :exec
+-- its bindings, redirects and post_email_bindings must be deleted first
+DELETE FROM generations
+WHERE id = $1;
+
+-- name: DeleteGenerationBindings :exec
+DELETE FROM bindings
+WHERE gen = $1;
+
+-- name: DeleteGenerationRedirects :exec
+DELETE FROM redirects
+WHERE gen = $1;
+
+-- name: DeleteGenerationPostEmailBindings :exec
+DELETE FROM post_email_bindings
+WHERE gen = $1;
+
+-- name: DeleteGenerationPosts :exec
+DELETE FROM generation_posts
+WHERE gen = $1;
+
+-- name: ListCheckedOutHashes :many
+-- the commits of blogs and previews that may be generated again
+SELECT live_hash::TEXT AS hash
+FROM blogs
+WHERE live_hash IS NOT NULL
+UNION
+SELECT head_hash::TEXT
+FROM blogs
+WHERE head_hash IS NOT NULL
+UNION
+SELECT hash
+FROM previews;
 
 -- name: InsertBinding :exec
 INSERT INTO bindings (
@@ -42,6 +189,24 @@ SELECT path
 FROM bindings
 WHERE gen = @generation AND url = $1;
 
+-- name: InsertRedirect :exec
+INSERT INTO redirects (
+	gen, priority, source, destination, status
+) VALUES (
+	$1, $2, $3, $4, $5
+);
+
+-- name: ListRedirects :many
+SELECT source, destination, status
+FROM redirects
+WHERE gen = $1
+ORDER BY priority;
+
+-- name: ListBindings :many
+SELECT url, path
+FROM bindings
+WHERE gen = $1;
+
 -- name: InsertPostEmailBinding :exec
 INSERT INTO post_email_bindings (
 	gen, url, html, text
@@ -49,6 +214,18 @@ INSERT INTO post_email_bindings (
 	$1, $2, $3, $4
 );
 
+-- name: InsertGenerationPost :exec
+INSERT INTO generation_posts (
+	gen, url, published_at, title
+) VALUES (
+	$1, $2, $3, $4
+);
+
+-- name: ListGenerationPosts :many
+SELECT url, published_at, title
+FROM generation_posts
+WHERE gen = $1;
+
 -- name: InsertRPost :exec
 INSERT INTO _r_posts (
 	url, blog, published_at, title
@@ -68,29 +245,23 @@ SELECT 1
 FROM posts
 WHERE url = $1 AND blog = $2;
 
--- name: RecordBlogVisit :exec
-INSERT INTO visits (
-	url, blog
-) VALUES (
-	$1, $2
-);
-
 -- name: ListActivePostsByBlog :many
 SELECT *
 FROM posts
 WHERE blog = $1 AND is_active = true
 ORDER BY published_at DESC;
 
--- name: CountVisits :one
-SELECT COUNT(*)
-FROM visits
-WHERE url = $1 AND blog = $2;
-
 -- name: CountEmailClicks :one
 SELECT COUNT(*)
 FROM subscriber_emails
 WHERE clicked = true AND url = $1 AND blog = $2;
 
+-- name: ListEmailClickCounts :many
+SELECT url, COUNT(*) AS clicks
+FROM subscriber_emails
+WHERE clicked = true AND blog = $1
+GROUP BY url;
+
 -- name: GetPostByToken :one
 SELECT *
 FROM posts
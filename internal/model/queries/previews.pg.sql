@@ -0,0 +1,35 @@
+-- name: UpsertPreview :exec
+INSERT INTO previews (
+	blog, label, branch, hash
+) VALUES (
+	$1, $2, $3, $4
+)
+ON CONFLICT (blog, label) DO UPDATE
+SET
+	branch = EXCLUDED.branch,
+	hash = EXCLUDED.hash,
+	updated_at = now();
+
+-- name: GetPreview :one
+SELECT *
+FROM previews
+WHERE blog = $1 AND label = $2;
+
+-- name: ListPreviewsByBlog :many
+SELECT *
+FROM previews
+WHERE blog = $1
+ORDER BY updated_at DESC;
+
+-- name: MarkPreviewGenerationsStale :exec
+UPDATE generations g
+SET stale = true
+FROM previews p
+WHERE p.blog = g.blog AND p.label = g.label AND p.hash = g.hash
+	AND g.preview = true
+	AND p.blog = $1
+	AND p.branch = $2;
+
+-- name: DeletePreviewByBranch :exec
+DELETE FROM previews
+WHERE blog = $1 AND branch = $2;
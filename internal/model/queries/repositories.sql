@@ -35,3 +35,12 @@ ORDER BY r.created_at ASC;
 DELETE
 FROM repositories
 WHERE repository_id = $1;
+
+-- name: TryLockGitdir :one
+-- a session lock (in class 2, that of git dirs) on updating the git dir, which
+-- must be released on the same connection. Returns false at once if the lock
+-- is held elsewhere.
+SELECT pg_try_advisory_lock(2, hashtext(sqlc.arg(gitdir)::TEXT));
+
+-- name: UnlockGitdir :one
+SELECT pg_advisory_unlock(2, hashtext(sqlc.arg(gitdir)::TEXT));
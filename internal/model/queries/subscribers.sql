@@ -28,15 +28,28 @@ SELECT *
 FROM subscribers
 WHERE unsubscribe_token = $1;
 
+-- name: ListSubscriptionsByBlogID :many
+-- the subscriptions of active subscribers and of those who unsubscribed
+-- when it was recorded, so that subscriber counts can be computed for past
+-- days
+SELECT created_at, unsubscribed_at
+FROM subscribers
+WHERE blog_id = $1
+	AND (status = 'active' OR unsubscribed_at IS NOT NULL);
+
 -- name: DeleteSubscriber :exec
 UPDATE subscribers
-SET status = 'unsubscribed'
-WHERE id = $1;
+SET
+	status = 'unsubscribed',
+	unsubscribed_at = now()
+WHERE id = $1 AND status = 'active';
 
 -- name: DeleteSubscriberByEmail :exec
 UPDATE subscribers
-SET status = 'unsubscribed'
-WHERE email = $1 AND blog_id = $2;
+SET
+	status = 'unsubscribed',
+	unsubscribed_at = now()
+WHERE email = $1 AND blog_id = $2 AND status = 'active';
 
 -- name: InsertSubscriberEmail :one
 INSERT INTO subscriber_emails (
@@ -48,5 +61,7 @@ RETURNING token;
 
 -- name: SetSubscriberEmailClicked :exec
 UPDATE subscriber_emails
-SET clicked = true
+SET
+	clicked = true,
+	clicked_at = COALESCE(clicked_at, now())
 WHERE token = $1;
@@ -0,0 +1,200 @@
+-- name: RecordBlogVisits :exec
+INSERT INTO visits (
+	url, blog, time, visitor, referrer, device,
+	utm_source, utm_medium, utm_campaign
+)
+SELECT
+	unnest(@urls::VARCHAR[]),
+	unnest(@blogs::TEXT[]),
+	unnest(@times::TIMESTAMPTZ[]),
+	unnest(@visitors::CHAR(64)[]),
+	unnest(@referrers::VARCHAR[]),
+	unnest(@devices::VARCHAR[]),
+	unnest(@sources::VARCHAR[]),
+	unnest(@mediums::VARCHAR[]),
+	unnest(@campaigns::VARCHAR[]);
+
+-- name: GetVisitorSalt :one
+INSERT INTO visitor_salts (day, salt)
+VALUES ($1, $2)
+ON CONFLICT (day) DO UPDATE SET day = EXCLUDED.day
+RETURNING salt;
+
+-- name: DeleteVisitorSaltsBefore :exec
+DELETE FROM visitor_salts
+WHERE day < $1;
+
+-- name: GetLatestRollupDay :one
+SELECT COALESCE(MAX(day), '1970-01-01')::DATE
+FROM daily_blog_visits;
+
+-- name: RollupVisits :exec
+INSERT INTO daily_visits (blog, url, day, views, uniques)
+SELECT
+	blog, url, (time AT TIME ZONE 'UTC')::DATE AS day,
+	COUNT(*), COUNT(DISTINCT visitor)
+FROM visits
+WHERE time >= (@since::DATE)::TIMESTAMP AT TIME ZONE 'UTC'
+GROUP BY blog, url, day
+ON CONFLICT (blog, url, day) DO UPDATE
+SET
+	views = EXCLUDED.views,
+	uniques = EXCLUDED.uniques;
+
+-- name: RollupBlogVisits :exec
+INSERT INTO daily_blog_visits (blog, day, views, uniques)
+SELECT
+	blog, (time AT TIME ZONE 'UTC')::DATE AS day,
+	COUNT(*), COUNT(DISTINCT visitor)
+FROM visits
+WHERE time >= (@since::DATE)::TIMESTAMP AT TIME ZONE 'UTC'
+GROUP BY blog, day
+ON CONFLICT (blog, day) DO UPDATE
+SET
+	views = EXCLUDED.views,
+	uniques = EXCLUDED.uniques;
+
+-- name: RollupVisitSources :exec
+INSERT INTO daily_visit_sources (
+	blog, url, day, referrer, utm_source, utm_medium, utm_campaign, views
+)
+SELECT
+	blog, url, (time AT TIME ZONE 'UTC')::DATE AS day,
+	referrer, utm_source, utm_medium, utm_campaign,
+	COUNT(*)
+FROM visits
+WHERE time >= (@since::DATE)::TIMESTAMP AT TIME ZONE 'UTC'
+	AND (referrer <> '' OR utm_source <> '' OR utm_medium <> ''
+		OR utm_campaign <> '')
+GROUP BY blog, url, day, referrer, utm_source, utm_medium, utm_campaign
+ON CONFLICT (blog, url, day, referrer, utm_source, utm_medium, utm_campaign)
+DO UPDATE SET views = EXCLUDED.views;
+
+-- name: DeleteVisitsBefore :execrows
+DELETE FROM visits
+WHERE time < $1;
+
+-- name: CountVisits :one
+SELECT
+	COALESCE(SUM(views), 0)::BIGINT AS views,
+	COALESCE(SUM(uniques), 0)::BIGINT AS visitors
+FROM daily_visits
+WHERE url = $1 AND blog = $2;
+
+-- name: ListPostVisitCounts :many
+SELECT
+	url,
+	SUM(views)::BIGINT AS views,
+	SUM(uniques)::BIGINT AS visitors
+FROM daily_visits
+WHERE blog = $1
+GROUP BY url;
+
+-- name: ListTopPostsByViews :many
+-- the blog's most viewed active posts
+SELECT
+	p.url, p.title, p.published_at,
+	COALESCE(SUM(v.views), 0)::BIGINT AS views
+FROM posts p
+LEFT JOIN daily_visits v ON v.blog = p.blog AND v.url = p.url
+WHERE p.blog = @blog AND p.is_active = true
+GROUP BY p.url, p.title, p.published_at
+ORDER BY views DESC, p.published_at DESC
+LIMIT @count;
+
+-- name: CountBlogVisits :one
+SELECT
+	COALESCE(SUM(views), 0)::BIGINT AS views,
+	COALESCE(SUM(uniques), 0)::BIGINT AS visitors
+FROM daily_blog_visits
+WHERE blog = $1;
+
+-- name: ListTopReferrers :many
+SELECT referrer, SUM(views)::BIGINT AS views
+FROM daily_visit_sources
+WHERE blog = @blog AND referrer <> ''
+	AND (sqlc.narg(url)::VARCHAR IS NULL OR url = sqlc.narg(url))
+GROUP BY referrer
+ORDER BY views DESC, referrer
+LIMIT @count;
+
+-- name: ListTopCampaigns :many
+SELECT utm_source, utm_medium, utm_campaign, SUM(views)::BIGINT AS views
+FROM daily_visit_sources
+WHERE blog = @blog
+	AND (utm_source <> '' OR utm_medium <> '' OR utm_campaign <> '')
+	AND (sqlc.narg(url)::VARCHAR IS NULL OR url = sqlc.narg(url))
+GROUP BY utm_source, utm_medium, utm_campaign
+ORDER BY views DESC, utm_source, utm_medium, utm_campaign
+LIMIT @count;
+
+-- name: ListTopReferrersByPost :many
+-- the top referrers of each of the blog's posts
+SELECT url, referrer, views
+FROM (
+	SELECT
+		url, referrer, SUM(views)::BIGINT AS views,
+		ROW_NUMBER() OVER (
+			PARTITION BY url ORDER BY SUM(views) DESC, referrer
+		) AS rank
+	FROM daily_visit_sources
+	WHERE blog = @blog AND referrer <> ''
+	GROUP BY url, referrer
+) r
+WHERE rank <= @count
+ORDER BY url, rank;
+
+-- name: ListTopCampaignsByPost :many
+-- the top campaigns of each of the blog's posts
+SELECT url, utm_source, utm_medium, utm_campaign, views
+FROM (
+	SELECT
+		url, utm_source, utm_medium, utm_campaign,
+		SUM(views)::BIGINT AS views,
+		ROW_NUMBER() OVER (
+			PARTITION BY url
+			ORDER BY SUM(views) DESC,
+				utm_source, utm_medium, utm_campaign
+		) AS rank
+	FROM daily_visit_sources
+	WHERE blog = @blog
+		AND (utm_source <> '' OR utm_medium <> '' OR utm_campaign <> '')
+	GROUP BY url, utm_source, utm_medium, utm_campaign
+) c
+WHERE rank <= @count
+ORDER BY url, rank;
+
+-- name: ListDailyBlogVisits :many
+SELECT day, views, uniques
+FROM daily_blog_visits
+WHERE blog = @blog AND day >= @since::DATE
+ORDER BY day;
+
+-- name: ListDailyPostVisits :many
+SELECT day, views, uniques
+FROM daily_visits
+WHERE blog = @blog AND url = @url AND day >= @since::DATE
+ORDER BY day;
+
+-- name: ListDailyPostVisitsBetween :many
+SELECT day, url, views, uniques
+FROM daily_visits
+WHERE blog = @blog AND day BETWEEN @from_day::DATE AND @to_day::DATE
+ORDER BY day, url;
+
+-- name: ListDailyBlogVisitsBetween :many
+SELECT day, views, uniques
+FROM daily_blog_visits
+WHERE blog = @blog AND day BETWEEN @from_day::DATE AND @to_day::DATE
+ORDER BY day;
+
+-- name: ListDailyEmailClicksBetween :many
+SELECT
+	(clicked_at AT TIME ZONE 'UTC')::DATE AS day, url,
+	COUNT(*) AS clicks
+FROM subscriber_emails
+WHERE blog = @blog AND clicked_at IS NOT NULL
+	AND (clicked_at AT TIME ZONE 'UTC')::DATE
+		BETWEEN @from_day::DATE AND @to_day::DATE
+GROUP BY day, url
+ORDER BY day, url;
@@ -25,7 +25,9 @@ ALTER DEFAULT PRIVILEGES IN SCHEMA hylodoc
 
 CREATE TABLE boots (
 	id		SERIAL		PRIMARY KEY,
-	created_at	TIMESTAMPTZ	NOT NULL	DEFAULT(now())
+	created_at	TIMESTAMPTZ	NOT NULL	DEFAULT(now()),
+	-- the host of the instance that booted
+	instance	VARCHAR(255)	NOT NULL	DEFAULT('')
 );
 CREATE VIEW boot_id AS
 	SELECT id FROM boots ORDER BY id DESC LIMIT 1;
@@ -126,11 +128,29 @@ CREATE TABLE blogs (
 	from_address		VARCHAR(255)	NOT NULL,
 	email_mode		email_mode	NOT NULL,
 	live_hash		VARCHAR(1000),
+	-- head of the live branch, which live_hash follows unless pinned
+	head_hash		VARCHAR(1000),
+	pinned			BOOLEAN		NOT NULL			DEFAULT(false),
 
 	gh_repository_id	BIGINT		NOT NULL	UNIQUE,
 	live_branch		VARCHAR(100)	NOT NULL,
+	-- directory of the repository the blog is generated from, '' for its
+	-- root
+	content_root		VARCHAR(1000)	NOT NULL			DEFAULT(''),
+	-- space-separated patterns of the other branches that are previewed,
+	-- '' for none
+	preview_branches	VARCHAR(1000)	NOT NULL			DEFAULT(''),
 
 	is_live			BOOLEAN		NOT NULL			DEFAULT(false),
+	offline_message		VARCHAR(1000),
+
+	-- public stats page is at /stats/<stats_token> when set
+	stats_token		UUID				UNIQUE,
+
+	-- entries in each feed and whether they carry the posts' HTML, NULL
+	-- for the defaults in conf.yml
+	feed_entries		INTEGER,
+	feed_full_content	BOOLEAN,
 
 	CONSTRAINT fk_user_id
 		FOREIGN KEY (user_id)
@@ -189,14 +209,64 @@ CREATE TABLE generations (
 	created_at	TIMESTAMPTZ	NOT NULL	DEFAULT(now()),
 	hash		VARCHAR(1000)	NOT NULL,
 	boot_id		INTEGER		NOT NULL	REFERENCES boots,
-	stale		BOOLEAN		NOT NULL	DEFAULT(false)
+	stale		BOOLEAN		NOT NULL	DEFAULT(false),
+	preview		BOOLEAN		NOT NULL	DEFAULT(false),
+	-- NULL once the blog has been deleted
+	blog		TEXT				REFERENCES blogs ON DELETE SET NULL,
+	-- label of the preview, '' for the live site
+	label		VARCHAR(63)	NOT NULL	DEFAULT(''),
+	-- the website directory the generation was written to
+	dir		VARCHAR(1000)	NOT NULL,
+	-- the site's title, '' if it has none
+	title		VARCHAR(1000)	NOT NULL	DEFAULT(''),
+
+	CHECK (preview = (label <> ''))
 );
 CREATE INDEX ON generations(stale);
 CREATE INDEX ON generations(boot_id);
+CREATE INDEX ON generations(blog, label);
 CREATE UNIQUE INDEX unique_hash_boot_id
-	ON generations (hash, boot_id)
+	ON generations (blog, label, hash, boot_id, preview)
 	WHERE stale = false;
 
+-- an interrupted build is one whose instance stopped before it finished, and
+-- is tried again
+CREATE TYPE build_status AS ENUM (
+	'building', 'succeeded', 'failed', 'interrupted'
+);
+
+-- every attempt to generate a blog or one of its previews
+CREATE TABLE builds (
+	id		SERIAL		PRIMARY KEY,
+	blog		TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	-- label of the preview, '' for the live site
+	label		VARCHAR(63)	NOT NULL	DEFAULT(''),
+	hash		VARCHAR(1000)	NOT NULL,
+	-- the blog's settings the build was made with
+	theme		blog_theme	NOT NULL	DEFAULT('lit'),
+	content_root	VARCHAR(1000)	NOT NULL	DEFAULT(''),
+	status		build_status	NOT NULL	DEFAULT('building'),
+	-- the boot of the instance running the build
+	boot_id		INTEGER		NOT NULL	REFERENCES boots,
+	started_at	TIMESTAMPTZ	NOT NULL	DEFAULT(now()),
+	finished_at	TIMESTAMPTZ,
+	-- the generation built, if the build succeeded
+	gen		INTEGER				REFERENCES generations ON DELETE SET NULL,
+	log		TEXT		NOT NULL	DEFAULT('')
+);
+CREATE INDEX ON builds(blog);
+
+-- branches other than the live branch, served at <label>--<subdomain>
+CREATE TABLE previews (
+	blog		TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	label		VARCHAR(63)	NOT NULL,
+	branch		VARCHAR(1000)	NOT NULL,
+	hash		VARCHAR(1000)	NOT NULL,
+	updated_at	TIMESTAMPTZ	NOT NULL	DEFAULT(now()),
+
+	PRIMARY KEY (blog, label)
+);
+
 CREATE TABLE bindings (
 	gen 	INTEGER		NOT NULL	REFERENCES generations,
 	url 	VARCHAR(1000)	NOT NULL,
@@ -205,6 +275,16 @@ CREATE TABLE bindings (
 	PRIMARY KEY (gen, url)
 );
 
+CREATE TABLE redirects (
+	gen		INTEGER		NOT NULL	REFERENCES generations,
+	priority	INTEGER		NOT NULL,
+	source		VARCHAR(1000)	NOT NULL,
+	destination	VARCHAR(1000)	NOT NULL,
+	status		INTEGER		NOT NULL,
+
+	PRIMARY KEY (gen, priority)
+);
+
 CREATE TABLE post_email_bindings (
 	gen		INTEGER		NOT NULL,
 	url		VARCHAR(1000)	NOT NULL, 	PRIMARY KEY (gen, url),
@@ -214,6 +294,17 @@ CREATE TABLE post_email_bindings (
 	text		VARCHAR(1000)	NOT NULL
 );
 
+-- the posts of a generation of the live site, recorded in _r_posts once its
+-- commit goes live
+CREATE TABLE generation_posts (
+	gen		INTEGER		NOT NULL	REFERENCES generations,
+	url		VARCHAR(1000)	NOT NULL,
+	published_at	TIMESTAMPTZ,
+	title		VARCHAR(1000)	NOT NULL,
+
+	PRIMARY KEY (gen, url)
+);
+
 CREATE TABLE _r_posts (
 	url		VARCHAR(1000)	NOT NULL,
 	blog		TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
@@ -239,7 +330,8 @@ CREATE VIEW posts AS
 		peb.text text_email_path
 	FROM _r_posts p
 	INNER JOIN blogs b ON b.id = p.blog
-	INNER JOIN generations g ON g.hash = b.live_hash
+	INNER JOIN generations g
+		ON (g.hash = b.live_hash AND g.preview = false)
 	INNER JOIN boot_id on boot_id.id = g.boot_id
 	LEFT JOIN (
 		bindings bind
@@ -249,15 +341,75 @@ CREATE VIEW posts AS
 	WHERE g.stale = false;
 
 CREATE TABLE visits (
-	id	SERIAL		PRIMARY KEY,
-	url	VARCHAR(1000)	NOT NULL,
-	blog	TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
-	time	TIMESTAMPTZ	NOT NULL	DEFAULT(now())
+	id		SERIAL		PRIMARY KEY,
+	url		VARCHAR(1000)	NOT NULL,
+	blog		TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	time		TIMESTAMPTZ	NOT NULL	DEFAULT(now()),
+
+	-- hash of the client's IP and user agent with the day's salt, so that
+	-- visitors can be counted but not identified or followed across days
+	visitor		CHAR(64)	NOT NULL,
+	-- host of the referring page on another site, if any
+	referrer	VARCHAR(253)	NOT NULL	DEFAULT(''),
+	-- 'desktop', 'mobile', 'tablet' or 'unknown'
+	device		VARCHAR(16)	NOT NULL,
+	-- utm_* parameters of the link followed, if any
+	utm_source	VARCHAR(255)	NOT NULL	DEFAULT(''),
+	utm_medium	VARCHAR(255)	NOT NULL	DEFAULT(''),
+	utm_campaign	VARCHAR(255)	NOT NULL	DEFAULT('')
 );
 CREATE INDEX ON visits(url);
 CREATE INDEX ON visits(blog);
 CREATE INDEX ON visits(time);
 
+-- each salt is deleted once its day is over, after which the day's visitor
+-- hashes can't be recomputed from an IP and user agent
+CREATE TABLE visitor_salts (
+	day	DATE	PRIMARY KEY,
+	salt	BYTEA	NOT NULL
+);
+
+-- daily rollups of visits (by UTC day), which the dashboard is served from
+-- and which outlive the raw rows. uniques are exact within a day only, since
+-- visitor hashes change with the salt.
+CREATE TABLE daily_visits (
+	blog	TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	url	VARCHAR(1000)	NOT NULL,
+	day	DATE		NOT NULL,
+	views	INTEGER		NOT NULL,
+	uniques	INTEGER		NOT NULL,
+
+	PRIMARY KEY (blog, url, day)
+);
+
+-- uniques across the whole blog, which can't be summed from daily_visits
+-- because a visitor may read several posts
+CREATE TABLE daily_blog_visits (
+	blog	TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	day	DATE		NOT NULL,
+	views	INTEGER		NOT NULL,
+	uniques	INTEGER		NOT NULL,
+
+	PRIMARY KEY (blog, day)
+);
+CREATE INDEX ON daily_blog_visits(day);
+
+-- visits with a referrer or campaign
+CREATE TABLE daily_visit_sources (
+	blog		TEXT		NOT NULL	REFERENCES blogs ON DELETE CASCADE,
+	url		VARCHAR(1000)	NOT NULL,
+	day		DATE		NOT NULL,
+	referrer	VARCHAR(253)	NOT NULL,
+	utm_source	VARCHAR(255)	NOT NULL,
+	utm_medium	VARCHAR(255)	NOT NULL,
+	utm_campaign	VARCHAR(255)	NOT NULL,
+	views		INTEGER		NOT NULL,
+
+	PRIMARY KEY (
+		blog, url, day, referrer, utm_source, utm_medium, utm_campaign
+	)
+);
+
 
 -- blog subscriber lists
 
@@ -271,6 +423,8 @@ CREATE TABLE subscribers (
 	status			subscription_status	NOT NULL			DEFAULT('active'),
 
 	created_at		TIMESTAMPTZ		NOT NULL			DEFAULT(now()),
+	-- NULL for subscribers who unsubscribed before it was recorded
+	unsubscribed_at		TIMESTAMPTZ,
 
 	CONSTRAINT fk_blog_id
 		FOREIGN KEY (blog_id)
@@ -291,6 +445,8 @@ CREATE TABLE subscriber_emails (
 	blog		TEXT		NOT NULL, 	FOREIGN KEY (url, blog)
 							REFERENCES _r_posts (url, blog),
 	clicked 	BOOLEAN		NOT NULL	DEFAULT(false),
+	-- first click
+	clicked_at	TIMESTAMPTZ,
 
 	UNIQUE (subscriber, url, blog)
 );
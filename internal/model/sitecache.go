@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+
+	"github.com/hylodoc/hylodoc.com/internal/sitecache"
+)
+
+/*
+ * The methods below shadow the generated queries that change how a host is
+ * routed to a file on disk, so that the routing cache is invalidated no
+ * matter where they are called from.
+ */
+
+func (s *Store) MarkBlogGenerationsStale(ctx context.Context, id string) error {
+	if err := s.Queries.MarkBlogGenerationsStale(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(id) })
+	return nil
+}
+
+func (s *Store) MarkGenerationsStaleByStripeSubscriptionID(
+	ctx context.Context, stripeSubscriptionID string,
+) error {
+	if err := s.Queries.MarkGenerationsStaleByStripeSubscriptionID(
+		ctx, stripeSubscriptionID,
+	); err != nil {
+		return err
+	}
+	s.AfterCommit(sitecache.InvalidateAll)
+	return nil
+}
+
+func (s *Store) InsertGeneration(
+	ctx context.Context, arg InsertGenerationParams,
+) (int32, error) {
+	gen, err := s.Queries.InsertGeneration(ctx, arg)
+	if err != nil {
+		return gen, err
+	}
+	s.AfterCommit(sitecache.InvalidateAll)
+	return gen, nil
+}
+
+/* the bindings and redirects of a collected generation may still be cached,
+ * though it is no longer served */
+func (s *Store) DeleteGeneration(ctx context.Context, id int32) error {
+	if err := s.Queries.DeleteGeneration(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(sitecache.InvalidateAll)
+	return nil
+}
+
+func (s *Store) UpdateBlogSubdomainByID(
+	ctx context.Context, arg UpdateBlogSubdomainByIDParams,
+) error {
+	if err := s.Queries.UpdateBlogSubdomainByID(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+func (s *Store) UpdateBlogDomainByID(
+	ctx context.Context, arg UpdateBlogDomainByIDParams,
+) error {
+	if err := s.Queries.UpdateBlogDomainByID(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+/* the fresh generation is the one matching the live hash */
+func (s *Store) UpdateBlogLiveHash(
+	ctx context.Context, arg UpdateBlogLiveHashParams,
+) error {
+	if err := s.Queries.UpdateBlogLiveHash(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+func (s *Store) UpdateBlogHeadHash(
+	ctx context.Context, arg UpdateBlogHeadHashParams,
+) error {
+	if err := s.Queries.UpdateBlogHeadHash(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+func (s *Store) PinBlog(ctx context.Context, arg PinBlogParams) error {
+	if err := s.Queries.PinBlog(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+func (s *Store) UnpinBlog(ctx context.Context, id string) error {
+	if err := s.Queries.UnpinBlog(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(id) })
+	return nil
+}
+
+func (s *Store) DeleteBlogByID(ctx context.Context, id string) error {
+	if err := s.Queries.DeleteBlogByID(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(id) })
+	return nil
+}
+
+func (s *Store) SetBlogToLive(ctx context.Context, id string) error {
+	if err := s.Queries.SetBlogToLive(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(id) })
+	return nil
+}
+
+func (s *Store) SetBlogToOffline(ctx context.Context, id string) error {
+	if err := s.Queries.SetBlogToOffline(ctx, id); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(id) })
+	return nil
+}
+
+func (s *Store) SetBlogOfflineMessage(
+	ctx context.Context, arg SetBlogOfflineMessageParams,
+) error {
+	if err := s.Queries.SetBlogOfflineMessage(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.ID) })
+	return nil
+}
+
+func (s *Store) InsertPreviewGeneration(
+	ctx context.Context, arg InsertPreviewGenerationParams,
+) (int32, error) {
+	gen, err := s.Queries.InsertPreviewGeneration(ctx, arg)
+	if err != nil {
+		return gen, err
+	}
+	s.AfterCommit(sitecache.InvalidateAll)
+	return gen, nil
+}
+
+/* a preview's fresh generation is the one matching its hash */
+func (s *Store) UpsertPreview(
+	ctx context.Context, arg UpsertPreviewParams,
+) error {
+	if err := s.Queries.UpsertPreview(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.Blog) })
+	return nil
+}
+
+func (s *Store) MarkPreviewGenerationsStale(
+	ctx context.Context, arg MarkPreviewGenerationsStaleParams,
+) error {
+	if err := s.Queries.MarkPreviewGenerationsStale(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.Blog) })
+	return nil
+}
+
+func (s *Store) DeletePreviewByBranch(
+	ctx context.Context, arg DeletePreviewByBranchParams,
+) error {
+	if err := s.Queries.DeletePreviewByBranch(ctx, arg); err != nil {
+		return err
+	}
+	s.AfterCommit(func() { sitecache.InvalidateBlog(arg.Blog) })
+	return nil
+}
@@ -3,7 +3,10 @@ package model
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // A Store models access to the DB in such a way that queries can be executed
@@ -13,9 +16,12 @@ type Store struct {
 	*Queries
 	_intx bool
 	_db   *sql.DB
+
+	/* run after commit when in a transaction */
+	_oncommit []func()
 }
 
-func NewStore(db *sql.DB) *Store { return &Store{New(db), false, db} }
+func NewStore(db *sql.DB) *Store { return &Store{New(db), false, db, nil} }
 
 func (s *Store) ExecTx(fn func(*Store) error) error {
 	if s._intx {
@@ -25,11 +31,127 @@ func (s *Store) ExecTx(fn func(*Store) error) error {
 	if err != nil {
 		return err
 	}
-	if err = fn(&Store{New(tx), true, s._db}); err != nil {
+	txstore := &Store{New(tx), true, s._db, nil}
+	if err = fn(txstore); err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
 			return fmt.Errorf("tx err: %w, rb err: %w", err, rbErr)
 		}
 		return err
 	}
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, f := range txstore._oncommit {
+		f()
+	}
+	return nil
+}
+
+const (
+	/* longest wait for another build of a blog, or update of a git dir, to
+	 * finish */
+	lockTimeout = 10 * time.Minute
+	/* how often a lock is tried while waiting */
+	lockRetry = time.Second
+)
+
+// ErrLockTimeout is returned by WithBlogBuildLock and WithGitdirLock if the
+// lock isn't released within lockTimeout.
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// WithBlogBuildLock runs fn holding a lock on generating the blog that is
+// shared by every instance, so that only one of them builds it at a time. It
+// gives up with ErrLockTimeout rather than wait on a stuck build forever.
+func (s *Store) WithBlogBuildLock(blogID string, fn func() error) error {
+	return s.withLock(
+		func(q *Queries) (bool, error) {
+			return q.TryLockBlogBuilds(context.TODO(), blogID)
+		},
+		func(q *Queries) (bool, error) {
+			return q.UnlockBlogBuilds(context.TODO(), blogID)
+		},
+		fn,
+	)
+}
+
+// WithGitdirLock is WithBlogBuildLock for updating a git dir, which the live
+// branch and previews of blogs share.
+func (s *Store) WithGitdirLock(gitdir string, fn func() error) error {
+	return s.withLock(
+		func(q *Queries) (bool, error) {
+			return q.TryLockGitdir(context.TODO(), gitdir)
+		},
+		func(q *Queries) (bool, error) {
+			return q.UnlockGitdir(context.TODO(), gitdir)
+		},
+		fn,
+	)
+}
+
+/* withLock runs fn holding a session-level advisory lock, taken on a
+ * connection of its own so that it is independent of any transaction s is in */
+func (s *Store) withLock(
+	trylock, unlock func(*Queries) (bool, error), fn func() error,
+) error {
+	conn, err := s._db.Conn(context.TODO())
+	if err != nil {
+		return fmt.Errorf("conn: %w", err)
+	}
+	defer conn.Close()
+	q := New(conn)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := trylock(q)
+		if err != nil {
+			return fmt.Errorf("lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockRetry)
+	}
+	fnerr := fn()
+	if ok, err := unlock(q); err != nil || !ok {
+		/* the lock lives as long as the connection, so discard it */
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		if err == nil {
+			err = fmt.Errorf("not held")
+		}
+		return errors.Join(fnerr, fmt.Errorf("unlock: %w", err))
+	}
+	return fnerr
+}
+
+// Boot records a new boot of the instance, marking the builds left building by
+// its earlier boots, whose workers are gone, as interrupted so that they are
+// built again. Other instances' builds are left alone.
+func (s *Store) Boot(ctx context.Context, instance string) (int32, error) {
+	var id int32
+	err := s.ExecTx(func(tx *Store) error {
+		var err error
+		if id, err = tx.Queries.Boot(ctx, instance); err != nil {
+			return err
+		}
+		if err := tx.InterruptBuilds(
+			ctx,
+			InterruptBuildsParams{Instance: instance, BootID: id},
+		); err != nil {
+			return fmt.Errorf("interrupt builds: %w", err)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// AfterCommit runs f immediately if s is not in a transaction, or otherwise
+// once the transaction has been committed.
+func (s *Store) AfterCommit(f func()) {
+	if !s._intx {
+		f()
+		return
+	}
+	s._oncommit = append(s._oncommit, f)
 }
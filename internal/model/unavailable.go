@@ -0,0 +1,32 @@
+package model
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// IsUnavailable reports whether err indicates that the DB cannot be reached,
+// as opposed to a query failing.
+func IsUnavailable(err error) bool {
+	var operr *net.OpError
+	if errors.As(err, &operr) {
+		return true
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var pqerr *pq.Error
+	if errors.As(err, &pqerr) {
+		switch pqerr.Code.Class() {
+		case "08", /* connection exception */
+			"53", /* insufficient resources */
+			"57": /* operator intervention (e.g. shutting down) */
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Package precompress writes compressed siblings of generated text files and
+// selects the best of them for a request's Accept-Encoding.
+package precompress
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+type Encoding struct {
+	Name string /* Content-Encoding token */
+	Ext  string /* suffix of sibling file on disk */
+}
+
+/* in order of preference */
+var encodings = []Encoding{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+var compressible = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+	".js":   true,
+	".svg":  true,
+	".xml":  true,
+	".json": true,
+}
+
+func IsCompressible(path string) bool {
+	return compressible[strings.ToLower(filepath.Ext(path))]
+}
+
+// CompressFile writes a sibling of path for each supported encoding. Files
+// that are not compressible are ignored.
+func CompressFile(path string) error {
+	if !IsCompressible(path) {
+		return nil
+	}
+	for _, enc := range encodings {
+		if err := compress(path, enc); err != nil {
+			return fmt.Errorf("%s: %w", enc.Name, err)
+		}
+	}
+	return nil
+}
+
+func compress(path string, enc Encoding) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + enc.Ext)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	w, err := newwriter(dst, enc)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+func newwriter(w io.Writer, enc Encoding) (io.WriteCloser, error) {
+	switch enc.Name {
+	case "br":
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	case "gzip":
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", enc.Name)
+	}
+}
+
+// Select returns the path of the preferred variant of path that exists on
+// disk and is acceptable according to the Accept-Encoding header, together
+// with its encoding. If there is none, it returns path and nil.
+func Select(path, acceptEncoding string) (string, *Encoding, error) {
+	if !IsCompressible(path) {
+		return path, nil, nil
+	}
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for i, enc := range encodings {
+		if !accepted.accepts(enc.Name) {
+			continue
+		}
+		variant := path + enc.Ext
+		if _, err := os.Stat(variant); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return "", nil, fmt.Errorf("stat: %w", err)
+		}
+		return variant, &encodings[i], nil
+	}
+	return path, nil, nil
+}
+
+type acceptset map[string]float64
+
+func parseAcceptEncoding(header string) acceptset {
+	set := acceptset{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		set[name] = q
+	}
+	return set
+}
+
+func (set acceptset) accepts(name string) bool {
+	if q, ok := set[name]; ok {
+		return q > 0
+	}
+	q, ok := set["*"]
+	return ok && q > 0
+}
@@ -0,0 +1,47 @@
+package precompress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-precompress")
+	if err != nil {
+		t.Fatalf("failed to create tmpDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(path, []byte("<p>hello</p>"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := CompressFile(path); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", path},
+		{"gzip", path + ".gz"},
+		{"gzip, deflate, br", path + ".br"},
+		{"br;q=0, gzip", path + ".gz"},
+		{"*", path + ".br"},
+		{"identity", path},
+	}
+	for _, test := range tests {
+		actual, _, err := Select(path, test.accept)
+		if err != nil {
+			t.Fatalf("select %q: %v", test.accept, err)
+		}
+		if actual != test.expected {
+			t.Errorf(
+				"%q: expected %s, got %s",
+				test.accept, test.expected, actual,
+			)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package ratelimit implements token-bucket rate limiting keyed by arbitrary
+// strings (client IPs, blog IDs).
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+/* idle buckets are swept at most this often */
+const sweepInterval = time.Minute
+
+// A Limiter allows, per key, bursts of up to burst requests and rate requests
+// per second on average. A Limiter with non-positive rate allows everything.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastsweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket, returning false if it is empty.
+func (l *Limiter) Allow(key string) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/* sweep drops buckets that have refilled, since they're equivalent to new
+ * ones, so that memory is bounded by the number of recently active keys */
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastsweep) < sweepInterval {
+		return
+	}
+	l.lastsweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(1, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("request %d within burst was limited", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatalf("request beyond burst was allowed")
+	}
+	if !l.Allow("b") {
+		t.Fatalf("other key was limited")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("a") {
+		t.Fatalf("refilled token was not available")
+	}
+	if l.Allow("a") {
+		t.Fatalf("only one token should have refilled")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(1, 2)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(sweepInterval)
+	l.Allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Errorf("refilled bucket was not swept")
+	}
+}
@@ -0,0 +1,113 @@
+// Package redirects parses the `_redirects' file that a repository may use to
+// define redirect rules for its site, and matches request paths against them.
+//
+// Each non-empty line that is not a comment (starting with `#') has the form
+//
+//	<source> [<destination>] [<status>]
+//
+// where source is a path that may end in `*' to match any suffix, which can be
+// substituted into the destination with `:splat'. The status is one of 301
+// (the default), 302 or 410, and the destination may be omitted only for 410.
+// Rules are matched in the order they appear. Invalid lines are skipped, so
+// that a typo doesn't discard the rest of the file.
+package redirects
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const Filename = "_redirects"
+
+type Rule struct {
+	Source      string
+	Destination string
+	Status      int
+}
+
+// Parse returns the rules of the valid lines read from r, and an error for
+// each invalid line that was skipped. The error is non-nil only if r cannot
+// be read.
+func Parse(r io.Reader) ([]Rule, []error, error) {
+	var (
+		rules   []Rule
+		invalid []error
+	)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseline(line)
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		rules = append(rules, *rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rules, invalid, nil
+}
+
+func parseline(line string) (*Rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 3 {
+		return nil, fmt.Errorf("too many fields")
+	}
+	rule := Rule{Source: fields[0], Status: http.StatusMovedPermanently}
+	if !strings.HasPrefix(rule.Source, "/") {
+		return nil, fmt.Errorf("source %q must begin with `/'", rule.Source)
+	}
+	if i := strings.Index(rule.Source, "*"); i != -1 &&
+		i != len(rule.Source)-1 {
+		return nil, fmt.Errorf("`*' can only end source")
+	}
+	rest := fields[1:]
+	if len(rest) > 0 {
+		if status, err := strconv.Atoi(rest[len(rest)-1]); err == nil {
+			rule.Status = status
+			rest = rest[:len(rest)-1]
+		}
+	}
+	if len(rest) > 0 {
+		rule.Destination = rest[0]
+	}
+	switch rule.Status {
+	case http.StatusMovedPermanently, http.StatusFound:
+		if rule.Destination == "" {
+			return nil, fmt.Errorf("%d requires destination", rule.Status)
+		}
+	case http.StatusGone:
+	default:
+		return nil, fmt.Errorf("unsupported status %d", rule.Status)
+	}
+	return &rule, nil
+}
+
+// Match returns the first rule matching path, with any `:splat' in its
+// destination substituted.
+func Match(rules []Rule, path string) (*Rule, bool) {
+	for _, rule := range rules {
+		prefix, wildcard := strings.CutSuffix(rule.Source, "*")
+		if !wildcard {
+			if path == rule.Source {
+				return &rule, true
+			}
+			continue
+		}
+		if splat, ok := strings.CutPrefix(path, prefix); ok {
+			rule.Destination = strings.ReplaceAll(
+				rule.Destination, ":splat", splat,
+			)
+			return &rule, true
+		}
+	}
+	return nil, false
+}
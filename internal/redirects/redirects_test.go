@@ -0,0 +1,86 @@
+package redirects
+
+import (
+	"strings"
+	"testing"
+)
+
+const file = `
+# moved posts
+/old-post        /new-post
+/drafts/*        /posts/:splat   302
+/gone            410
+/also-gone       /ignored        410
+`
+
+func TestMatch(t *testing.T) {
+	rules, invalid, err := Parse(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(invalid) > 0 {
+		t.Fatalf("invalid lines: %v", invalid)
+	}
+	tests := []struct {
+		path        string
+		destination string
+		status      int
+	}{
+		{"/old-post", "/new-post", 301},
+		{"/drafts/a/b", "/posts/a/b", 302},
+		{"/gone", "", 410},
+		{"/also-gone", "/ignored", 410},
+	}
+	for _, test := range tests {
+		rule, ok := Match(rules, test.path)
+		if !ok {
+			t.Errorf("%s: expected match", test.path)
+			continue
+		}
+		if rule.Destination != test.destination ||
+			rule.Status != test.status {
+			t.Errorf(
+				"%s: expected %s %d, got %s %d",
+				test.path, test.destination, test.status,
+				rule.Destination, rule.Status,
+			)
+		}
+	}
+	if _, ok := Match(rules, "/old-post/"); ok {
+		t.Errorf("exact source matched different path")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, line := range []string{
+		"old /new",
+		"/old",
+		"/old /new 200",
+		"/a*b /new",
+		"/old /new 301 extra",
+	} {
+		rules, invalid, err := Parse(strings.NewReader(line))
+		if err != nil {
+			t.Fatalf("%q: parse: %v", line, err)
+		}
+		if len(rules) != 0 || len(invalid) != 1 {
+			t.Errorf("%q: expected line to be skipped as invalid", line)
+		}
+	}
+}
+
+func TestParseSkipsInvalid(t *testing.T) {
+	rules, invalid, err := Parse(strings.NewReader(
+		"/a /b\nold /new\n/c /d 302\n",
+	))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rules) != 2 || rules[1].Source != "/c" {
+		t.Errorf("expected valid rules around invalid line, got %v", rules)
+	}
+	if len(invalid) != 1 ||
+		!strings.HasPrefix(invalid[0].Error(), "line 2:") {
+		t.Errorf("expected line 2 to be invalid, got %v", invalid)
+	}
+}
@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/precompress"
+	"github.com/hylodoc/hylodoc.com/internal/routing/internal/usersite"
+	"github.com/hylodoc/hylodoc.com/internal/sitecache"
+)
+
+/* setCacheHeaders sets Cache-Control and an ETag derived from the file's
+ * contents (and encoding, if any), so that an unchanged file revalidates
+ * across generations. http.ServeContent handles the conditional requests
+ * (If-None-Match, If-Modified-Since) based on these. */
+func setCacheHeaders(
+	w http.ResponseWriter, b *usersite.Binding, enc *precompress.Encoding,
+) error {
+	hash, err := filehash(b)
+	if err != nil {
+		return fmt.Errorf("file hash: %w", err)
+	}
+	etag := hash
+	if enc != nil {
+		etag = fmt.Sprintf("%s-%s", etag, enc.Name)
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", etag))
+	w.Header().Set("Cache-Control", cachecontrol(b.Path()))
+	return nil
+}
+
+func cachecontrol(path string) string {
+	/* assets keep their URLs across generations too, so are cached only
+	 * briefly before they revalidate */
+	if isasset(path) {
+		return fmt.Sprintf(
+			"public, max-age=%d",
+			int(config.Config.Routing.AssetMaxAge.Seconds()),
+		)
+	}
+	/* documents must revalidate for readers to see new generations
+	 * immediately */
+	return "no-cache"
+}
+
+/* documents are HTML pages and the feeds etc. we generate, whose URLs are
+ * stable across generations */
+var documentTypes = []string{
+	"text/html", "text/xml", "text/plain", "application/json",
+}
+
+func isasset(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return false
+	}
+	ctype := mime.TypeByExtension(ext)
+	for _, doc := range documentTypes {
+		if strings.HasPrefix(ctype, doc) {
+			return false
+		}
+	}
+	return true
+}
+
+/* generated files are never modified in place (every generation is written
+ * to a new directory), so their hashes are cached with the generation */
+func filehash(b *usersite.Binding) (string, error) {
+	if h, ok := sitecache.GetFileHash(b.Generation(), b.Path()); ok {
+		return h, nil
+	}
+	f, err := os.Open(b.Path())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	h := hex.EncodeToString(hasher.Sum(nil))[:16]
+	sitecache.PutFileHash(b.Generation(), b.Path(), h)
+	return h, nil
+}
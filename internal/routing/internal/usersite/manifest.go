@@ -0,0 +1,139 @@
+package usersite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/redirects"
+	"github.com/hylodoc/hylodoc.com/internal/sitecache"
+)
+
+var ErrNoManifest = errors.New("no manifest")
+
+/* A manifest is the last-known-good state of a host, kept on disk so that its
+ * site can still be served while the DB is unavailable. */
+type manifest struct {
+	Blog       sitecache.Blog
+	Generation int32
+	Bindings   map[string]string
+	Redirects  []redirects.Rule
+}
+
+// GetSiteFromManifest returns the site for host as of the last generation
+// served for it, without querying the DB.
+func GetSiteFromManifest(host string) (*Site, error) {
+	b, err := os.ReadFile(manifestPath(host))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoManifest
+		}
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return &Site{host: host, blog: m.Blog, manifest: &m}, nil
+}
+
+type manifestkey struct {
+	blog sitecache.Blog
+	gen  int32
+}
+
+/* what the manifest of each canonical host was last written for by this
+ * process */
+var written sync.Map
+
+/* updateManifest writes the host's manifest in the background if the blog or
+ * its generation changed since it was last written */
+func (site *Site) updateManifest(gen int32, store *model.Store) {
+	key := manifestkey{site.blog, gen}
+	host := canonicalHost(site.host)
+	if last, ok := written.Load(host); ok && last.(manifestkey) == key {
+		return
+	}
+	written.Store(host, key)
+	go func() {
+		if err := site.writeManifest(gen, store); err != nil {
+			/* retry on next request */
+			written.Delete(host)
+			log.Printf("manifest for %s: %v\n", site.host, err)
+		}
+	}()
+}
+
+func (site *Site) writeManifest(gen int32, store *model.Store) error {
+	rows, err := store.ListBindings(context.TODO(), gen)
+	if err != nil {
+		return fmt.Errorf("list bindings: %w", err)
+	}
+	rules, err := getRedirects(gen, store)
+	if err != nil {
+		return fmt.Errorf("redirects: %w", err)
+	}
+	m := manifest{
+		Blog:       site.blog,
+		Generation: gen,
+		Bindings:   make(map[string]string, len(rows)),
+		Redirects:  rules,
+	}
+	for _, row := range rows {
+		m.Bindings[row.Url] = row.Path
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return writeFileAtomic(manifestPath(site.host), b)
+}
+
+/* writeFileAtomic replaces the file at path so that readers never see it
+ * partially written */
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".manifest-*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func manifestPath(host string) string {
+	return filepath.Join(
+		config.Config.Hylodoc.ManifestsPath,
+		url.PathEscape(canonicalHost(host))+".json",
+	)
+}
+
+/* canonicalHost is the host in the form its manifest is kept under, so that
+ * the variants of a host that resolve to the same site share one: host names
+ * are case-insensitive and may be written fully qualified */
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hylodoc/hylodoc.com/internal/assert"
@@ -15,16 +17,23 @@ import (
 	"github.com/hylodoc/hylodoc.com/internal/config"
 	"github.com/hylodoc/hylodoc.com/internal/dns"
 	"github.com/hylodoc/hylodoc.com/internal/model"
+	"github.com/hylodoc/hylodoc.com/internal/redirects"
+	"github.com/hylodoc/hylodoc.com/internal/sitecache"
+	"github.com/hylodoc/hylodoc.com/internal/visits"
 )
 
 type Site struct {
-	blogID string
+	host string
+	blog sitecache.Blog
+	/* set when the site is served from its manifest */
+	manifest *manifest
 }
 
 var ErrIsService = errors.New("host is service name")
 var ErrPageNotFound = errors.New("page not found")
 var ErrUnknownSubdomain = errors.New("unknown subdomain")
 var ErrUnknownDomain = errors.New("unknown domain")
+var ErrUnknownPreview = errors.New("unknown preview")
 
 func GetSite(host string, s *model.Store) (*Site, error) {
 	if host == config.Config.Hylodoc.RootDomain {
@@ -34,78 +43,220 @@ func GetSite(host string, s *model.Store) (*Site, error) {
 	if err != nil {
 		return nil, fmt.Errorf("get blog: %w", err)
 	}
-	return &Site{blog}, nil
+	return &Site{host: host, blog: blog}, nil
 }
 
-func getBlog(host string, s *model.Store) (string, error) {
+func (site *Site) ID() string { return site.blog.ID }
+
+func (site *Site) IsLive() bool { return site.blog.IsLive }
+
+func (site *Site) OfflineMessage() string { return site.blog.OfflineMessage }
+
+// IsPreview indicates whether the site is the preview of a branch other than
+// the blog's live branch.
+func (site *Site) IsPreview() bool { return site.blog.Preview != "" }
+
+// IsDegraded indicates whether the site is being served from its manifest
+// because the DB is unavailable.
+func (site *Site) IsDegraded() bool { return site.manifest != nil }
+
+func getBlog(host string, s *model.Store) (sitecache.Blog, error) {
+	if b, ok := sitecache.GetBlog(host); ok {
+		return b, nil
+	}
+	epoch := sitecache.Epoch()
+	blog, preview, err := queryBlog(host, s)
+	if err != nil {
+		return sitecache.Blog{}, err
+	}
+	b := sitecache.Blog{
+		ID:             blog.ID,
+		IsLive:         blog.IsLive,
+		OfflineMessage: blog.OfflineMessage.String,
+		Preview:        preview,
+	}
+	sitecache.PutBlog(epoch, host, b)
+	return b, nil
+}
+
+/* queryBlog returns the blog the host resolves to and the label of the preview
+ * it is for, if any */
+func queryBlog(host string, s *model.Store) (*model.Blog, string, error) {
 	/* check for subdomain first because it's the more common case */
-	blogID, err := getBlogBySubdomain(host, s)
+	blog, preview, err := getBlogBySubdomain(host, s)
 	if err == nil {
-		return blogID, nil
+		return blog, preview, nil
 	}
 	if !errors.Is(err, errNotSubdomainForm) {
-		return "", fmt.Errorf("subdomain: %w", err)
+		return nil, "", fmt.Errorf("subdomain: %w", err)
 	}
 	assert.Assert(errors.Is(err, errNotSubdomainForm))
 
-	blog, err := s.GetBlogByDomain(context.TODO(), host)
+	b, err := s.GetBlogByDomain(context.TODO(), host)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrUnknownDomain
+			return nil, "", ErrUnknownDomain
 		}
-		return "", fmt.Errorf("domain: %w", err)
+		return nil, "", fmt.Errorf("domain: %w", err)
 	}
-	return blog.ID, nil
+	return &b, "", nil
 }
 
 var errNotSubdomainForm = errors.New("not subdomain form")
 
-func getBlogBySubdomain(host string, s *model.Store) (string, error) {
+func getBlogBySubdomain(
+	host string, s *model.Store,
+) (*model.Blog, string, error) {
 	/* `.hylodoc.com' (dot followed by service name) must follow host */
 	subdomain, found := strings.CutSuffix(
 		host,
 		fmt.Sprintf(".%s", config.Config.Hylodoc.RootDomain),
 	)
 	if !found {
-		return "", errNotSubdomainForm
+		return nil, "", errNotSubdomainForm
+	}
+	/* previews are served at <label>--<subdomain> */
+	label, subdomain, ispreview := strings.Cut(
+		subdomain, dns.PreviewSeparator,
+	)
+	if !ispreview {
+		subdomain, label = label, ""
 	}
 	sub, err := dns.ParseSubdomain(subdomain)
 	if err != nil {
-		return "", fmt.Errorf("parse: %w", err)
+		return nil, "", fmt.Errorf("parse: %w", err)
 	}
 	blog, err := s.GetBlogBySubdomain(context.TODO(), sub)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrUnknownSubdomain
+			return nil, "", ErrUnknownSubdomain
 		}
-		return "", fmt.Errorf("query error: %w", err)
+		return nil, "", fmt.Errorf("query error: %w", err)
 	}
-	return blog.ID, nil
+	if ispreview {
+		if _, err := s.GetPreview(
+			context.TODO(),
+			model.GetPreviewParams{Blog: blog.ID, Label: label},
+		); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, "", ErrUnknownPreview
+			}
+			return nil, "", fmt.Errorf("preview: %w", err)
+		}
+	}
+	return &blog, label, nil
 }
 
-func (site *Site) RecordVisit(path string, store *model.Store) error {
-	return store.RecordBlogVisit(
-		context.TODO(),
-		model.RecordBlogVisitParams{Url: path, Blog: site.blogID},
-	)
+func (site *Site) RecordVisit(
+	r *http.Request, ip string, c visits.Campaign, rec *visits.Recorder,
+) {
+	rec.Record(visits.Visit{
+		Url:       r.URL.Path,
+		Blog:      site.blog.ID,
+		Time:      time.Now(),
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Referrer:  r.Referer(),
+		Host:      r.Host,
+		Campaign:  c,
+	})
+}
+
+// A Binding is the file on disk that a URL resolves to in a generation.
+type Binding struct {
+	gen  int32
+	path string
 }
 
-func (site *Site) GetBinding(path string, store *model.Store) (string, error) {
-	gen, err := blog.GetFreshGeneration(site.blogID, store)
+func (b *Binding) Generation() int32 { return b.gen }
+func (b *Binding) Path() string      { return b.path }
+
+func (site *Site) GetBinding(path string, store *model.Store) (*Binding, error) {
+	if m := site.manifest; m != nil {
+		binding, ok := m.Bindings[path]
+		if !ok {
+			return nil, ErrPageNotFound
+		}
+		return &Binding{m.Generation, binding}, nil
+	}
+	gen, err := site.getGeneration(store)
 	if err != nil {
-		return "", fmt.Errorf("generation: %w", err)
+		return nil, fmt.Errorf("generation: %w", err)
+	}
+	site.updateManifest(gen, store)
+	if binding, ok := sitecache.GetBinding(gen, path); ok {
+		return &Binding{gen, binding}, nil
 	}
+	epoch := sitecache.Epoch()
 	binding, err := store.GetBinding(
 		context.TODO(),
 		model.GetBindingParams{Generation: gen, Url: path},
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrPageNotFound
+			return nil, ErrPageNotFound
 		}
-		return "", fmt.Errorf("query: %w", err)
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	sitecache.PutBinding(epoch, gen, path, binding)
+	return &Binding{gen, binding}, nil
+}
+
+// GetRedirect returns the first of the generation's redirect rules that
+// matches path.
+func (site *Site) GetRedirect(
+	path string, store *model.Store,
+) (*redirects.Rule, bool, error) {
+	if m := site.manifest; m != nil {
+		rule, ok := redirects.Match(m.Redirects, path)
+		return rule, ok, nil
+	}
+	gen, err := site.getGeneration(store)
+	if err != nil {
+		return nil, false, fmt.Errorf("generation: %w", err)
+	}
+	rules, err := getRedirects(gen, store)
+	if err != nil {
+		return nil, false, fmt.Errorf("redirects: %w", err)
+	}
+	rule, ok := redirects.Match(rules, path)
+	return rule, ok, nil
+}
+
+func getRedirects(gen int32, store *model.Store) ([]redirects.Rule, error) {
+	if rules, ok := sitecache.GetRedirects(gen); ok {
+		return rules, nil
+	}
+	epoch := sitecache.Epoch()
+	rows, err := store.ListRedirects(context.TODO(), gen)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	rules := make([]redirects.Rule, len(rows))
+	for i, row := range rows {
+		rules[i] = redirects.Rule{
+			Source:      row.Source,
+			Destination: row.Destination,
+			Status:      int(row.Status),
+		}
+	}
+	sitecache.PutRedirects(epoch, gen, rules)
+	return rules, nil
+}
+
+func (site *Site) getGeneration(store *model.Store) (int32, error) {
+	b := site.blog
+	if gen, ok := sitecache.GetGeneration(b.ID, b.Preview); ok {
+		return gen, nil
+	}
+	epoch := sitecache.Epoch()
+	/* never generates: a build is queued if the generation isn't fresh */
+	gen, err := blog.LatestGeneration(b.ID, b.Preview, store)
+	if err != nil {
+		return -1, err
 	}
-	return binding, nil
+	sitecache.PutGeneration(epoch, b.ID, b.Preview, gen)
+	return gen, nil
 }
 
 func (site *Site) RecordEmailClick(url *url.URL, store *model.Store) bool {
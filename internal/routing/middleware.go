@@ -5,20 +5,24 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/hylodoc/hylodoc.com/internal/app/handler"
 	"github.com/hylodoc/hylodoc.com/internal/assert"
+	"github.com/hylodoc/hylodoc.com/internal/blog"
 	"github.com/hylodoc/hylodoc.com/internal/model"
 	"github.com/hylodoc/hylodoc.com/internal/routing/internal/usersite"
 	"github.com/hylodoc/hylodoc.com/internal/session"
+	"github.com/hylodoc/hylodoc.com/internal/visits"
 )
 
 type RoutingService struct {
-	store *model.Store
+	store  *model.Store
+	visits *visits.Recorder
 }
 
-func NewRoutingService(s *model.Store) *RoutingService {
-	return &RoutingService{store: s}
+func NewRoutingService(s *model.Store, v *visits.Recorder) *RoutingService {
+	return &RoutingService{store: s, visits: v}
 }
 
 func (s *RoutingService) Middleware(next http.Handler) http.Handler {
@@ -30,7 +34,8 @@ func (s *RoutingService) Middleware(next http.Handler) http.Handler {
 			assert.Assert(ok)
 			assert.Assert(sesh != nil)
 			switch {
-			case errors.Is(err, usersite.ErrPageNotFound):
+			case errors.Is(err, usersite.ErrPageNotFound),
+				errors.Is(err, usersite.ErrUnknownPreview):
 				handler.NotFound(w, r)
 				break
 			case errors.Is(err, usersite.ErrUnknownSubdomain):
@@ -39,6 +44,9 @@ func (s *RoutingService) Middleware(next http.Handler) http.Handler {
 			case errors.Is(err, usersite.ErrUnknownDomain):
 				handler.NotFoundDomain(w, r)
 				break
+			case errors.Is(err, blog.ErrNotGenerated):
+				handler.SiteBuilding(w, r)
+				break
 			default:
 				sesh.Println("unknown host error:", err)
 				handler.HandleError(w, r, err)
@@ -51,37 +59,174 @@ func (s *RoutingService) Middleware(next http.Handler) http.Handler {
 func (s *RoutingService) tryRoute(
 	w http.ResponseWriter, r *http.Request, hylodoc http.Handler,
 ) error {
+	if !allow(w, r, ipLimiter, "ip", clientIP(r)) {
+		return nil
+	}
 	site, err := usersite.GetSite(r.Host, s.store)
 	if err != nil {
 		if errors.Is(err, usersite.ErrIsService) {
 			hylodoc.ServeHTTP(w, r)
 			return nil
 		}
-		return fmt.Errorf("get site: %w", err)
-	}
-	/* site visit is only recorded after checking for email token because
-	 * the redirect would cause two visits to be recorded. */
-	if site.RecordEmailClick(r.URL, s.store) {
-		http.Redirect(
-			w, r,
-			stripEmailToken(r.URL),
-			http.StatusPermanentRedirect,
-		)
+		if !model.IsUnavailable(err) {
+			return fmt.Errorf("get site: %w", err)
+		}
+		if site, err = degradedSite(r, err); err != nil {
+			return err
+		}
+	}
+	/* checked once, whether the site is served normally or degraded */
+	if !allow(w, r, blogLimiter, "blog", site.ID()) {
+		return nil
+	}
+	if site.IsDegraded() {
+		return s.routeSite(w, r, site)
+	}
+	header := w.Header().Clone()
+	sw := &startedWriter{ResponseWriter: w}
+	err = s.routeSite(sw, r, site)
+	if err == nil || !model.IsUnavailable(err) || sw.started {
+		return err
+	}
+	/* the DB went away after the site was found, so serve it degraded
+	 * instead, dropping any headers set on the way */
+	for k := range w.Header() {
+		delete(w.Header(), k)
+	}
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	if site, err = degradedSite(r, err); err != nil {
+		return err
+	}
+	return s.routeSite(w, r, site)
+}
+
+/* degradedSite gets the site from its manifest when the DB can't be reached,
+ * so that the last-known-good generation stays up. Nothing is recorded in
+ * degraded mode. */
+func degradedSite(r *http.Request, cause error) (*usersite.Site, error) {
+	sesh, ok := r.Context().Value(session.CtxSessionKey).(*session.Session)
+	assert.Assert(ok)
+	site, err := usersite.GetSiteFromManifest(r.Host)
+	if err != nil {
+		if errors.Is(err, usersite.ErrNoManifest) {
+			return nil, fmt.Errorf("db unavailable: %w", cause)
+		}
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	sesh.Printf("degraded mode for %s: %v\n", r.Host, cause)
+	return site, nil
+}
+
+/* startedWriter records whether a response has been started, after which the
+ * request can no longer be served another way */
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *startedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (s *RoutingService) routeSite(
+	w http.ResponseWriter, r *http.Request, site *usersite.Site,
+) error {
+	/* nothing is recorded for offline sites */
+	if !site.IsLive() {
+		handler.SiteOffline(w, r, site.OfflineMessage())
 		return nil
 	}
-	if err := site.RecordVisit(r.URL.Path, s.store); err != nil {
-		return fmt.Errorf("record visit: %w", err)
+	/* previews mustn't be indexed or counted among the blog's visits */
+	if site.IsPreview() {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		return s.serve(w, r, site)
+	}
+	if site.IsDegraded() {
+		return s.serve(w, r, site)
+	}
+	/* site visit is only recorded after checking for email token and
+	 * campaign because the redirect would cause two visits to be recorded.
+	 * The campaign is recorded with the visit to the canonical URL. */
+	if site.RecordEmailClick(r.URL, s.store) || hasCampaign(r.URL) {
+		setCampaign(w, r.URL)
+		/* not permanent, or browsers that cached it would skip us on
+		 * later visits from the same link */
+		http.Redirect(w, r, stripTracking(r.URL), http.StatusFound)
+		return nil
 	}
-	filepath, err := site.GetBinding(r.URL.Path, s.store)
+	site.RecordVisit(r, clientIP(r), takeCampaign(w, r), s.visits)
+	return s.serve(w, r, site)
+}
+
+func (s *RoutingService) serve(
+	w http.ResponseWriter, r *http.Request, site *usersite.Site,
+) error {
+	binding, err := site.GetBinding(r.URL.Path, s.store)
 	if err != nil {
+		if errors.Is(err, usersite.ErrPageNotFound) {
+			return s.tryRedirect(w, r, site)
+		}
 		return fmt.Errorf("get filepath: %w", err)
 	}
-	http.ServeFile(w, r, filepath)
+	if err := serveBinding(w, r, binding); err != nil {
+		return fmt.Errorf("serve binding: %w", err)
+	}
 	return nil
 }
 
-func stripEmailToken(url *url.URL) string {
-	u := *url
-	u.RawQuery = ""
-	return u.String()
+/* tryRedirect applies the site's redirect rules to paths that have no binding,
+ * so that a generated page always takes precedence over a rule */
+func (s *RoutingService) tryRedirect(
+	w http.ResponseWriter, r *http.Request, site *usersite.Site,
+) error {
+	rule, ok, err := site.GetRedirect(r.URL.Path, s.store)
+	if err != nil {
+		return fmt.Errorf("get redirect: %w", err)
+	}
+	if !ok {
+		return s.notFound(w, r, site)
+	}
+	if rule.Status == http.StatusGone {
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+		return nil
+	}
+	http.Redirect(w, r, redirectLocation(rule.Destination, r.URL), rule.Status)
+	return nil
+}
+
+/* notFound serves the site's own 404 page, falling back to ours for
+ * generations that don't have one */
+func (s *RoutingService) notFound(
+	w http.ResponseWriter, r *http.Request, site *usersite.Site,
+) error {
+	binding, err := site.GetBinding(blog.NotFoundURL, s.store)
+	if err != nil {
+		if errors.Is(err, usersite.ErrPageNotFound) {
+			return usersite.ErrPageNotFound
+		}
+		return fmt.Errorf("get 404 page: %w", err)
+	}
+	if err := serveNotFound(w, r, binding); err != nil {
+		return fmt.Errorf("serve 404 page: %w", err)
+	}
+	return nil
+}
+
+/* redirectLocation carries the request's query over to the destination unless
+ * the destination has its own */
+func redirectLocation(dest string, url *url.URL) string {
+	if url.RawQuery == "" || strings.Contains(dest, "?") {
+		return dest
+	}
+	return dest + "?" + url.RawQuery
 }
@@ -0,0 +1,55 @@
+package routing
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/hylodoc/hylodoc.com/internal/app/handler"
+	"github.com/hylodoc/hylodoc.com/internal/config"
+	"github.com/hylodoc/hylodoc.com/internal/metrics"
+	"github.com/hylodoc/hylodoc.com/internal/ratelimit"
+)
+
+/* limiters are shared by every RoutingService */
+var (
+	ipLimiter        = newLimiter(config.Config.Routing.RateLimit.IP)
+	blogLimiter      = newLimiter(config.Config.Routing.RateLimit.Blog)
+	subscribeLimiter = newLimiter(config.Config.Routing.RateLimit.Subscribe)
+)
+
+func newLimiter(c config.RateLimit) *ratelimit.Limiter {
+	return ratelimit.New(c.Rate, c.Burst)
+}
+
+/* allow responds with 429 if the key's bucket in the limiter is empty */
+func allow(
+	w http.ResponseWriter, r *http.Request,
+	l *ratelimit.Limiter, name, key string,
+) bool {
+	if l.Allow(key) {
+		return true
+	}
+	metrics.RecordRateLimited(name)
+	handler.TooManyRequests(w, r)
+	return false
+}
+
+// LimitSubscriptions is middleware for the subscription route, which is
+// limited more strictly than other requests because each one sends an email.
+func LimitSubscriptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allow(w, r, subscribeLimiter, "subscribe", clientIP(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+/* clientIP ignores X-Forwarded-For because we terminate connections ourselves,
+ * so it would only let clients choose their own key */
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
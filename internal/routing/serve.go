@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/hylodoc/hylodoc.com/internal/precompress"
+	"github.com/hylodoc/hylodoc.com/internal/routing/internal/usersite"
+)
+
+/* serveBinding serves the best precompressed variant of the binding's file
+ * that the client accepts, falling back to the file itself. */
+func serveBinding(
+	w http.ResponseWriter, r *http.Request, b *usersite.Binding,
+) error {
+	path, enc, err := selectVariant(w, r, b)
+	if err != nil {
+		return err
+	}
+	if err := setCacheHeaders(w, b, enc); err != nil {
+		return fmt.Errorf("set cache headers: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	http.ServeContent(w, r, b.Path(), info.ModTime(), f)
+	return nil
+}
+
+/* serveNotFound serves the site's 404 page. It bypasses http.ServeContent,
+ * which would answer conditional requests with 304 and always send 200. */
+func serveNotFound(
+	w http.ResponseWriter, r *http.Request, b *usersite.Binding,
+) error {
+	path, _, err := selectVariant(w, r, b)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	return nil
+}
+
+/* selectVariant picks the precompressed variant of the binding's file to
+ * serve and sets the headers describing its encoding */
+func selectVariant(
+	w http.ResponseWriter, r *http.Request, b *usersite.Binding,
+) (string, *precompress.Encoding, error) {
+	path, enc, err := precompress.Select(
+		b.Path(), r.Header.Get("Accept-Encoding"),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("select variant: %w", err)
+	}
+	if precompress.IsCompressible(b.Path()) {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if enc != nil {
+		w.Header().Set("Content-Encoding", enc.Name)
+		/* otherwise it would be inferred from the variant's suffix */
+		if ctype := mime.TypeByExtension(
+			filepath.Ext(b.Path()),
+		); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+	}
+	return path, enc, nil
+}
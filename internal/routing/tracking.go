@@ -0,0 +1,74 @@
+package routing
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/hylodoc/hylodoc.com/internal/visits"
+)
+
+/* carries a link's campaign across the redirect to its canonical URL, where
+ * the visit is recorded */
+const campaignCookie = "hylodoc_campaign"
+
+/* hasCampaign reports whether the URL has utm_* parameters */
+func hasCampaign(url *url.URL) bool {
+	for key := range url.Query() {
+		if visits.IsCampaignParam(key) {
+			return true
+		}
+	}
+	return false
+}
+
+/* setCampaign remembers the campaign in the URL for the next request */
+func setCampaign(w http.ResponseWriter, url *url.URL) {
+	c, ok := visits.CampaignFromQuery(url.Query())
+	if !ok {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     campaignCookie,
+		Value:    c.Query().Encode(),
+		Path:     "/",
+		MaxAge:   60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+/* takeCampaign returns the campaign remembered by setCampaign, if any, and
+ * forgets it so that it is only recorded once */
+func takeCampaign(w http.ResponseWriter, r *http.Request) visits.Campaign {
+	cookie, err := r.Cookie(campaignCookie)
+	if err != nil {
+		return visits.Campaign{}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     campaignCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q, err := url.ParseQuery(cookie.Value)
+	if err != nil {
+		return visits.Campaign{}
+	}
+	c, _ := visits.CampaignFromQuery(q)
+	return c
+}
+
+/* stripTracking removes the email token and campaign parameters from the URL,
+ * leaving the canonical URL of the page */
+func stripTracking(url *url.URL) string {
+	u := *url
+	q := u.Query()
+	for key := range q {
+		if key == "subscriber" || visits.IsCampaignParam(key) {
+			q.Del(key)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,250 @@
+// Package sitecache is a process-local cache of the lookups done when routing
+// a request to a user site: host to blog, blog to the generation served,
+// (generation, url) to path on disk, generation to redirect rules and
+// (generation, path) to the file's content hash.
+//
+// Invalidation is driven by the model.Store, which calls InvalidateBlog or
+// InvalidateAll whenever a query that could change one of the above runs. To
+// avoid a reader caching a value it read from the DB before a concurrent
+// invalidation, callers take an Epoch before querying and pass it to the Put
+// functions, which discard the value if an invalidation happened in between.
+//
+// Since only the Store of this process invalidates it, host and generation
+// entries expire after a TTL, which bounds how long a change made by another
+// instance goes unnoticed. Bindings, redirects and file hashes belong to a
+// generation and never change, so they are kept until it is superseded or
+// invalidated.
+package sitecache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/redirects"
+)
+
+// A Blog is the routing-relevant state of the blog a host resolves to.
+// Preview is the label of the branch preview the host is for, if any.
+type Blog struct {
+	ID             string
+	IsLive         bool
+	OfflineMessage string
+	Preview        string
+}
+
+type genkey struct{ blogID, preview string }
+
+type hostentry struct {
+	blog    Blog
+	expires time.Time
+}
+
+type genentry struct {
+	gen     int32
+	expires time.Time
+}
+
+type cache struct {
+	mu sync.RWMutex
+
+	ttl      time.Duration
+	epoch    uint64
+	hosts    map[string]hostentry
+	gens     map[genkey]genentry
+	bindings map[int32]map[string]string
+	rules    map[int32][]redirects.Rule
+	/* generation to file path to content hash */
+	hashes map[int32]map[string]string
+}
+
+/* overridden in tests */
+var now = time.Now
+
+var c = newcache()
+
+func newcache() *cache {
+	return &cache{
+		ttl:      30 * time.Second,
+		hosts:    map[string]hostentry{},
+		gens:     map[genkey]genentry{},
+		bindings: map[int32]map[string]string{},
+		rules:    map[int32][]redirects.Rule{},
+		hashes:   map[int32]map[string]string{},
+	}
+}
+
+// SetTTL sets how long host and generation entries are served from the cache.
+func SetTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("non-positive ttl %s", ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	return nil
+}
+
+// Epoch returns the current invalidation epoch. It must be taken before the
+// DB is queried for a value that is subsequently passed to a Put function.
+func Epoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+func GetBlog(host string) (Blog, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.hosts[host]
+	if !ok || !now().Before(e.expires) {
+		return Blog{}, false
+	}
+	return e.blog, true
+}
+
+func PutBlog(epoch uint64, host string, b Blog) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	c.hosts[host] = hostentry{b, now().Add(c.ttl)}
+}
+
+// GetGeneration returns the generation served for the blog, or of one of its
+// previews if preview is non-empty.
+func GetGeneration(blogID, preview string) (int32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.gens[genkey{blogID, preview}]
+	if !ok || !now().Before(e.expires) {
+		return 0, false
+	}
+	return e.gen, true
+}
+
+func PutGeneration(epoch uint64, blogID, preview string, gen int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	key := genkey{blogID, preview}
+	old, ok := c.gens[key]
+	c.gens[key] = genentry{gen, now().Add(c.ttl)}
+	if ok && old.gen != gen {
+		c.freeGeneration(old.gen)
+	}
+}
+
+/* freeGeneration drops the bindings and redirects of a generation that has
+ * been superseded, unless it is still served for another blog or preview */
+func (c *cache) freeGeneration(gen int32) {
+	if c.cached(gen) {
+		return
+	}
+	delete(c.bindings, gen)
+	delete(c.rules, gen)
+	delete(c.hashes, gen)
+}
+
+func GetBinding(gen int32, url string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	path, ok := c.bindings[gen][url]
+	return path, ok
+}
+
+func PutBinding(epoch uint64, gen int32, url, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	if _, ok := c.bindings[gen]; !ok {
+		c.bindings[gen] = map[string]string{}
+	}
+	c.bindings[gen][url] = path
+}
+
+func GetRedirects(gen int32) ([]redirects.Rule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rules, ok := c.rules[gen]
+	return rules, ok
+}
+
+func PutRedirects(epoch uint64, gen int32, rules []redirects.Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch {
+		return
+	}
+	c.rules[gen] = rules
+}
+
+// GetFileHash returns the content hash of a file of the generation.
+func GetFileHash(gen int32, path string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.hashes[gen][path]
+	return h, ok
+}
+
+// PutFileHash caches the content hash of a file of the generation for as long
+// as the generation is cached. Files are never modified in place, so no epoch
+// is needed.
+func PutFileHash(gen int32, path, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cached(gen) {
+		return
+	}
+	if _, ok := c.hashes[gen]; !ok {
+		c.hashes[gen] = map[string]string{}
+	}
+	c.hashes[gen][path] = hash
+}
+
+/* cached reports whether the generation is served for a blog or preview */
+func (c *cache) cached(gen int32) bool {
+	for _, e := range c.gens {
+		if e.gen == gen {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidateBlog drops every entry that refers to the given blog.
+func InvalidateBlog(blogID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for host, e := range c.hosts {
+		if e.blog.ID == blogID {
+			delete(c.hosts, host)
+		}
+	}
+	for key, e := range c.gens {
+		if key.blogID == blogID {
+			delete(c.bindings, e.gen)
+			delete(c.rules, e.gen)
+			delete(c.hashes, e.gen)
+			delete(c.gens, key)
+		}
+	}
+}
+
+// InvalidateAll empties the cache.
+func InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.hosts = map[string]hostentry{}
+	c.gens = map[genkey]genentry{}
+	c.bindings = map[int32]map[string]string{}
+	c.rules = map[int32][]redirects.Rule{}
+	c.hashes = map[int32]map[string]string{}
+}
@@ -0,0 +1,126 @@
+package sitecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvalidateBlog(t *testing.T) {
+	InvalidateAll()
+
+	epoch := Epoch()
+	PutBlog(epoch, "a.example.com", Blog{ID: "a"})
+	PutBlog(epoch, "b.example.com", Blog{ID: "b"})
+	PutGeneration(epoch, "a", "", 1)
+	PutGeneration(epoch, "a", "draft", 2)
+	PutBinding(epoch, 1, "/", "/websites/a/index.html")
+
+	InvalidateBlog("a")
+
+	if _, ok := GetBlog("a.example.com"); ok {
+		t.Errorf("host for invalidated blog still cached")
+	}
+	if _, ok := GetGeneration("a", ""); ok {
+		t.Errorf("generation for invalidated blog still cached")
+	}
+	if _, ok := GetGeneration("a", "draft"); ok {
+		t.Errorf("preview generation for invalidated blog still cached")
+	}
+	if _, ok := GetBinding(1, "/"); ok {
+		t.Errorf("binding for invalidated generation still cached")
+	}
+	if b, ok := GetBlog("b.example.com"); !ok || b.ID != "b" {
+		t.Errorf("expected other blog to remain cached, got %q", b.ID)
+	}
+}
+
+func TestPutAfterInvalidationDiscarded(t *testing.T) {
+	InvalidateAll()
+
+	/* value read from the DB before a concurrent invalidation */
+	epoch := Epoch()
+	InvalidateBlog("a")
+	PutBlog(epoch, "a.example.com", Blog{ID: "a"})
+
+	if _, ok := GetBlog("a.example.com"); ok {
+		t.Fatalf("stale value was cached")
+	}
+}
+
+func TestEntriesExpire(t *testing.T) {
+	InvalidateAll()
+	t0 := time.Now()
+	now = func() time.Time { return t0 }
+	defer func() { now = time.Now }()
+	if err := SetTTL(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	epoch := Epoch()
+	PutBlog(epoch, "a.example.com", Blog{ID: "a"})
+	PutGeneration(epoch, "a", "", 1)
+	PutBinding(epoch, 1, "/", "/websites/a/index.html")
+
+	now = func() time.Time { return t0.Add(59 * time.Second) }
+	if _, ok := GetBlog("a.example.com"); !ok {
+		t.Errorf("host expired before ttl")
+	}
+
+	now = func() time.Time { return t0.Add(time.Minute) }
+	if _, ok := GetBlog("a.example.com"); ok {
+		t.Errorf("host still cached after ttl")
+	}
+	if _, ok := GetGeneration("a", ""); ok {
+		t.Errorf("generation still cached after ttl")
+	}
+	if _, ok := GetBinding(1, "/"); !ok {
+		t.Errorf("binding of generation should outlive ttl")
+	}
+}
+
+func TestSupersededGenerationFreed(t *testing.T) {
+	InvalidateAll()
+
+	epoch := Epoch()
+	PutGeneration(epoch, "a", "", 1)
+	PutBinding(epoch, 1, "/", "/websites/a/1/index.html")
+	PutRedirects(epoch, 1, nil)
+
+	PutGeneration(epoch, "a", "", 2)
+
+	if _, ok := GetBinding(1, "/"); ok {
+		t.Errorf("binding of superseded generation still cached")
+	}
+	if _, ok := GetRedirects(1); ok {
+		t.Errorf("redirects of superseded generation still cached")
+	}
+}
+
+func TestFileHashesFollowGeneration(t *testing.T) {
+	InvalidateAll()
+
+	epoch := Epoch()
+	PutFileHash(1, "/websites/a/1/style.css", "abc")
+	if _, ok := GetFileHash(1, "/websites/a/1/style.css"); ok {
+		t.Errorf("hash cached for a generation that isn't served")
+	}
+
+	PutGeneration(epoch, "a", "", 1)
+	PutFileHash(1, "/websites/a/1/style.css", "abc")
+	if h, ok := GetFileHash(1, "/websites/a/1/style.css"); !ok || h != "abc" {
+		t.Errorf("expected cached hash, got %q", h)
+	}
+
+	PutGeneration(epoch, "a", "", 2)
+	if _, ok := GetFileHash(1, "/websites/a/1/style.css"); ok {
+		t.Errorf("hash of superseded generation still cached")
+	}
+}
+
+func TestSetTTLRejectsNonPositive(t *testing.T) {
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if err := SetTTL(ttl); err == nil {
+			t.Errorf("ttl %s accepted", ttl)
+		}
+	}
+}
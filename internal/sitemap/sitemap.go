@@ -0,0 +1,64 @@
+// Package sitemap renders the sitemap.xml and default robots.txt of a site.
+package sitemap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	SitemapPath = "/sitemap.xml"
+	RobotsPath  = "/robots.txt"
+)
+
+type URL struct {
+	/* absolute */
+	Loc     string
+	LastMod time.Time
+}
+
+type urlset struct {
+	XMLName xml.Name  `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []siteurl `xml:"url"`
+}
+
+type siteurl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func Render(urls []URL) ([]byte, error) {
+	sorted := make([]URL, len(urls))
+	copy(sorted, urls)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Loc < sorted[j].Loc
+	})
+	var doc urlset
+	for _, u := range sorted {
+		su := siteurl{Loc: u.Loc}
+		if !u.LastMod.IsZero() {
+			su.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		doc.URLs = append(doc.URLs, su)
+	}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// Robots returns a robots.txt allowing everything and pointing to the sitemap
+// of the site at link.
+func Robots(link string) []byte {
+	return []byte(fmt.Sprintf(
+		"User-agent: *\nAllow: /\n\nSitemap: %s%s\n", link, SitemapPath,
+	))
+}
+
+// NoRobots returns a robots.txt disallowing everything.
+func NoRobots() []byte {
+	return []byte("User-agent: *\nDisallow: /\n")
+}
@@ -0,0 +1,34 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	b, err := Render([]URL{
+		{Loc: "https://notes.example.com/post"},
+		{
+			Loc:     "https://notes.example.com/",
+			LastMod: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc urlset
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(doc.URLs) != 2 {
+		t.Fatalf("expected 2 urls, got %d", len(doc.URLs))
+	}
+	if u := doc.URLs[0]; u.Loc != "https://notes.example.com/" ||
+		u.LastMod != "2024-01-01T00:00:00Z" {
+		t.Errorf("unexpected first url %+v", u)
+	}
+	if u := doc.URLs[1]; u.LastMod != "" {
+		t.Errorf("expected no lastmod, got %q", u.LastMod)
+	}
+}
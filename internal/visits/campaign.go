@@ -0,0 +1,58 @@
+package visits
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+/* longest value of a utm_* parameter we record */
+const campaignValueLength = 255
+
+// A Campaign is what the utm_source, utm_medium and utm_campaign parameters of
+// a link say about where it was shared.
+type Campaign struct {
+	Source   string
+	Medium   string
+	Campaign string
+}
+
+var campaignParams = []string{"utm_source", "utm_medium", "utm_campaign"}
+
+// IsCampaignParam reports whether the query parameter key is one of those
+// that make up a Campaign, or another utm_* parameter that doesn't belong in
+// a canonical URL.
+func IsCampaignParam(key string) bool {
+	return strings.HasPrefix(key, "utm_")
+}
+
+// CampaignFromQuery returns the campaign described by the query, if any.
+func CampaignFromQuery(q url.Values) (Campaign, bool) {
+	c := Campaign{
+		Source:   truncate(q.Get("utm_source")),
+		Medium:   truncate(q.Get("utm_medium")),
+		Campaign: truncate(q.Get("utm_campaign")),
+	}
+	return c, !c.IsZero()
+}
+
+func (c Campaign) IsZero() bool { return c == Campaign{} }
+
+// Query encodes the campaign as it would appear in a link.
+func (c Campaign) Query() url.Values {
+	q := url.Values{}
+	for i, v := range []string{c.Source, c.Medium, c.Campaign} {
+		if v != "" {
+			q.Set(campaignParams[i], v)
+		}
+	}
+	return q
+}
+
+func truncate(s string) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= campaignValueLength {
+		return s
+	}
+	return string([]rune(s)[:campaignValueLength])
+}
@@ -0,0 +1,29 @@
+package visits
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCampaignFromQuery(t *testing.T) {
+	q, _ := url.ParseQuery("utm_source=newsletter&utm_campaign=launch&page=2")
+	c, ok := CampaignFromQuery(q)
+	if !ok {
+		t.Fatal("expected campaign")
+	}
+	want := Campaign{Source: "newsletter", Campaign: "launch"}
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	if got := c.Query().Encode(); got != "utm_campaign=launch&utm_source=newsletter" {
+		t.Errorf("Query() = %q", got)
+	}
+	if _, ok := CampaignFromQuery(url.Values{"page": {"2"}}); ok {
+		t.Error("expected no campaign")
+	}
+	long := url.Values{"utm_source": {strings.Repeat("é", 300)}}
+	if c, _ := CampaignFromQuery(long); len([]rune(c.Source)) != campaignValueLength {
+		t.Errorf("source not truncated: %d runes", len([]rune(c.Source)))
+	}
+}
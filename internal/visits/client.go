@@ -0,0 +1,76 @@
+package visits
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceUnknown = "unknown"
+)
+
+/* substrings of the user agents of crawlers, monitors and link previewers,
+ * lowercased */
+var botAgents = []string{
+	"bot", "crawl", "spider", "slurp", "archiver", "facebookexternalhit",
+	"embedly", "preview", "monitor", "uptime", "pingdom", "lighthouse",
+	"headless", "phantomjs", "curl/", "wget/", "python-", "go-http-client",
+	"java/", "okhttp", "axios/", "node-fetch", "feed", "rss",
+}
+
+// IsBot reports whether the user agent belongs to an automated client. An
+// empty user agent is taken to be a bot, since browsers always send one.
+func IsBot(ua string) bool {
+	ua = strings.ToLower(strings.TrimSpace(ua))
+	if ua == "" {
+		return true
+	}
+	for _, s := range botAgents {
+		if strings.Contains(ua, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceClass buckets a browser's user agent into desktop, mobile or tablet.
+func DeviceClass(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"), strings.Contains(ua, "iphone"),
+		strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	case strings.Contains(ua, "windows"), strings.Contains(ua, "macintosh"),
+		strings.Contains(ua, "x11"), strings.Contains(ua, "cros"):
+		return DeviceDesktop
+	default:
+		return DeviceUnknown
+	}
+}
+
+// ReferrerHost returns the host of the referring page, or the empty string if
+// there is none or it is on the site itself.
+func ReferrerHost(referrer, host string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	ref := strings.ToLower(u.Hostname())
+	if ref == stripPort(strings.ToLower(host)) {
+		return ""
+	}
+	return ref
+}
+
+func stripPort(host string) string {
+	if u, err := url.Parse("//" + host); err == nil {
+		return u.Hostname()
+	}
+	return host
+}
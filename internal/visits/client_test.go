@@ -0,0 +1,71 @@
+package visits
+
+import "testing"
+
+const (
+	chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	iphone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	ipad   = "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	galaxy = "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	pixel  = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+)
+
+func TestIsBot(t *testing.T) {
+	tests := []struct {
+		ua  string
+		bot bool
+	}{
+		{chrome, false},
+		{iphone, false},
+		{"", true},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true},
+		{"facebookexternalhit/1.1", true},
+		{"curl/8.4.0", true},
+		{"Feedly/1.0 (+http://www.feedly.com/fetcher.html)", true},
+	}
+	for _, tt := range tests {
+		if got := IsBot(tt.ua); got != tt.bot {
+			t.Errorf("IsBot(%q) = %v, want %v", tt.ua, got, tt.bot)
+		}
+	}
+}
+
+func TestDeviceClass(t *testing.T) {
+	tests := []struct {
+		ua     string
+		device string
+	}{
+		{chrome, DeviceDesktop},
+		{iphone, DeviceMobile},
+		{ipad, DeviceTablet},
+		{galaxy, DeviceTablet},
+		{pixel, DeviceMobile},
+		{"Lynx/2.9.0", DeviceUnknown},
+	}
+	for _, tt := range tests {
+		if got := DeviceClass(tt.ua); got != tt.device {
+			t.Errorf("DeviceClass(%q) = %q, want %q", tt.ua, got, tt.device)
+		}
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct {
+		referrer, host, want string
+	}{
+		{"https://news.ycombinator.com/item?id=1", "a.hylodoc.com", "news.ycombinator.com"},
+		{"https://WWW.Google.com/", "a.hylodoc.com", "www.google.com"},
+		{"https://a.hylodoc.com/post", "a.hylodoc.com", ""},
+		{"http://localhost:8080/x", "localhost:8080", ""},
+		{"android-app://com.slack", "a.hylodoc.com", ""},
+		{"", "a.hylodoc.com", ""},
+	}
+	for _, tt := range tests {
+		if got := ReferrerHost(tt.referrer, tt.host); got != tt.want {
+			t.Errorf(
+				"ReferrerHost(%q, %q) = %q, want %q",
+				tt.referrer, tt.host, got, tt.want,
+			)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package visits
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+// RunRollups rolls raw visits up into daily totals every period, and deletes
+// raw visits older than retention once they have been rolled up. It only
+// returns if its arguments are invalid; failed runs are retried next period.
+func RunRollups(s *model.Store, period, retention time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("no rollup period")
+	}
+	if retention <= 0 {
+		return fmt.Errorf("no retention")
+	}
+	for {
+		if err := s.ExecTx(func(tx *model.Store) error {
+			return rollup(tx, retention)
+		}); err != nil {
+			log.Println("visit rollup:", err)
+		}
+		time.Sleep(period)
+	}
+}
+
+func rollup(s *model.Store, retention time.Duration) error {
+	since, err := redoFrom(s)
+	if err != nil {
+		return fmt.Errorf("redo from: %w", err)
+	}
+	if err := s.RollupVisits(context.TODO(), since); err != nil {
+		return fmt.Errorf("visits: %w", err)
+	}
+	if err := s.RollupBlogVisits(context.TODO(), since); err != nil {
+		return fmt.Errorf("blog visits: %w", err)
+	}
+	if err := s.RollupVisitSources(context.TODO(), since); err != nil {
+		return fmt.Errorf("sources: %w", err)
+	}
+	/* never delete visits from the days that will be redone next time */
+	next, err := redoFrom(s)
+	if err != nil {
+		return fmt.Errorf("redo from: %w", err)
+	}
+	cutoff := time.Now().Add(-retention)
+	if cutoff.After(next) {
+		cutoff = next
+	}
+	n, err := s.DeleteVisitsBefore(context.TODO(), cutoff)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if n > 0 {
+		log.Printf("deleted %d visits before %s\n", n, cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
+
+/* redoFrom returns the start of the earliest day that a rollup must redo: the
+ * latest day rolled up may have been incomplete, and visits buffered across
+ * midnight may reach the db after the day before it was rolled up */
+func redoFrom(s *model.Store) (time.Time, error) {
+	latest, err := s.GetLatestRollupDay(context.TODO())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return latest.AddDate(0, 0, -1), nil
+}
@@ -0,0 +1,225 @@
+// Package visits records user-site visits off the request path: visits are
+// buffered in memory and written to the db in batches.
+package visits
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hylodoc/hylodoc.com/internal/metrics"
+	"github.com/hylodoc/hylodoc.com/internal/model"
+)
+
+// A Visit is a page view as seen by the server. The client's IP and user
+// agent are only kept in memory until the visit is flushed, when they are
+// replaced by a salted hash.
+type Visit struct {
+	Url       string
+	Blog      string
+	Time      time.Time
+	IP        string
+	UserAgent string
+	/* Referer header, and Host of the request it came with */
+	Referrer string
+	Host     string
+	Campaign Campaign
+}
+
+type visit struct {
+	url, blog        string
+	time             time.Time
+	client           string
+	referrer, device string
+	campaign         Campaign
+}
+
+type Recorder struct {
+	store     *model.Store
+	batchSize int
+	period    time.Duration
+
+	/* guards sends on visits against Close */
+	mu     sync.RWMutex
+	closed bool
+	visits chan visit
+	done   chan struct{}
+
+	/* the current day's salt, only used by Run */
+	saltDay time.Time
+	salt    []byte
+}
+
+// NewRecorder returns a Recorder that holds up to bufferSize visits and writes
+// them batchSize at a time, waiting at most period between writes.
+func NewRecorder(
+	s *model.Store, bufferSize, batchSize int, period time.Duration,
+) (*Recorder, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("no flush period")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("no batch size")
+	}
+	/* Record never blocks, so without a buffer almost every visit would
+	 * be dropped */
+	if bufferSize <= 0 {
+		return nil, fmt.Errorf("no buffer size")
+	}
+	return &Recorder{
+		store:     s,
+		batchSize: batchSize,
+		period:    period,
+		visits:    make(chan visit, bufferSize),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Record buffers v without blocking. The visit is dropped if the buffer is
+// full, so that page views never wait on the db. Visits by bots are ignored.
+func (r *Recorder) Record(v Visit) {
+	if IsBot(v.UserAgent) {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		metrics.RecordVisitsDropped("closed", 1)
+		return
+	}
+	select {
+	case r.visits <- visit{
+		url:      v.Url,
+		blog:     v.Blog,
+		time:     v.Time,
+		client:   v.IP + "\n" + v.UserAgent,
+		referrer: ReferrerHost(v.Referrer, v.Host),
+		device:   DeviceClass(v.UserAgent),
+		campaign: v.Campaign,
+	}:
+	default:
+		metrics.RecordVisitsDropped("full", 1)
+	}
+}
+
+// Run flushes buffered visits whenever a batch fills up or the flush period
+// elapses, until the Recorder is closed.
+func (r *Recorder) Run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	batch := make([]visit, 0, r.batchSize)
+	for {
+		select {
+		case v, ok := <-r.visits:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, v)
+			if len(batch) < r.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		r.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// Close stops accepting visits and waits for those buffered to be flushed.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.visits)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *Recorder) flush(batch []visit) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := r.tryFlush(batch); err != nil {
+		log.Printf("flush %d visits: %v\n", len(batch), err)
+		metrics.RecordVisitsDropped("error", len(batch))
+		return
+	}
+	metrics.RecordVisitsFlushed(len(batch))
+}
+
+func (r *Recorder) tryFlush(batch []visit) error {
+	arg := model.RecordBlogVisitsParams{
+		Urls:      make([]string, len(batch)),
+		Blogs:     make([]string, len(batch)),
+		Times:     make([]time.Time, len(batch)),
+		Visitors:  make([]string, len(batch)),
+		Referrers: make([]string, len(batch)),
+		Devices:   make([]string, len(batch)),
+		Sources:   make([]string, len(batch)),
+		Mediums:   make([]string, len(batch)),
+		Campaigns: make([]string, len(batch)),
+	}
+	for i, v := range batch {
+		salt, err := r.getSalt(v.time)
+		if err != nil {
+			return fmt.Errorf("salt: %w", err)
+		}
+		arg.Urls[i] = v.url
+		arg.Blogs[i] = v.blog
+		arg.Times[i] = v.time
+		arg.Visitors[i] = visitorHash(salt, v.client)
+		arg.Referrers[i] = v.referrer
+		arg.Devices[i] = v.device
+		arg.Sources[i] = v.campaign.Source
+		arg.Mediums[i] = v.campaign.Medium
+		arg.Campaigns[i] = v.campaign.Campaign
+	}
+	return r.store.RecordBlogVisits(context.TODO(), arg)
+}
+
+/* getSalt returns the salt for the (UTC) day of t, creating it if need be.
+ * Salts of earlier days are deleted as soon as a new day starts. */
+func (r *Recorder) getSalt(t time.Time) ([]byte, error) {
+	day := t.UTC().Truncate(24 * time.Hour)
+	if day.Equal(r.saltDay) {
+		return r.salt, nil
+	}
+	/* visits buffered across midnight are hashed with the new day's salt
+	 * because the old one may already be gone */
+	if day.Before(r.saltDay) {
+		return r.salt, nil
+	}
+	fresh := make([]byte, 32)
+	if _, err := rand.Read(fresh); err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	salt, err := r.store.GetVisitorSalt(
+		context.TODO(),
+		model.GetVisitorSaltParams{Day: day, Salt: fresh},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	if err := r.store.DeleteVisitorSaltsBefore(
+		context.TODO(), day,
+	); err != nil {
+		return nil, fmt.Errorf("delete old: %w", err)
+	}
+	r.saltDay, r.salt = day, salt
+	return salt, nil
+}
+
+func visitorHash(salt []byte, client string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(client))
+	return hex.EncodeToString(h.Sum(nil))
+}